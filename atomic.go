@@ -0,0 +1,76 @@
+package bloom
+
+/*
+An atomic Bloom filter can be added to and tested from many goroutines at
+once without locks. Its bits are kept in 64-bit words that are read with
+atomic loads and set with compare-and-swap, and keys are hashed with
+pooled hashers, so no state is shared between calls but the words
+themselves. A key added by one goroutine tests present in every Test that
+starts after its Add returns; there are no false negatives.
+
+Keys are located exactly as in a BloomFilter made by New with the same
+parameters.
+*/
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+)
+
+type AtomicBloomFilter struct {
+	locator *BloomFilter // computes locations, has no bitset
+	words   []uint64
+}
+
+// Create a new atomic Bloom filter with _m_ bits and _k_ hashing
+// functions. Zero values of _m_ or _k_ are taken as 1.
+func NewAtomic(m uint, k uint) *AtomicBloomFilter {
+	if m == 0 {
+		m = 1
+	}
+	if k == 0 {
+		k = 1
+	}
+	return &AtomicBloomFilter{
+		locator: &BloomFilter{m: m, k: k, hashers: hasherPool(fnv.New64), mask: maskFor(m)},
+		words:   make([]uint64, (m+63)/64),
+	}
+}
+
+// Return the capacity, _m_, of an atomic Bloom filter
+func (f *AtomicBloomFilter) Cap() uint {
+	return f.locator.m
+}
+
+// Return the number of hash functions used
+func (f *AtomicBloomFilter) K() uint {
+	return f.locator.k
+}
+
+// Add data to the atomic Bloom filter; safe for concurrent use. Returns
+// the filter (allows chaining)
+func (f *AtomicBloomFilter) Add(data []byte) *AtomicBloomFilter {
+	a, b := f.locator.key_hashes(data)
+	f.locator.forEachHashLocation(a, b, func(loc uint) bool {
+		w, bit := &f.words[loc/64], uint64(1)<<(loc%64)
+		for {
+			old := atomic.LoadUint64(w)
+			if old&bit != 0 || atomic.CompareAndSwapUint64(w, old, old|bit) {
+				return true
+			}
+		}
+	})
+	return f
+}
+
+// Tests for the presence of data in the atomic Bloom filter; safe for
+// concurrent use
+func (f *AtomicBloomFilter) Test(data []byte) bool {
+	a, b := f.locator.key_hashes(data)
+	present := true
+	f.locator.forEachHashLocation(a, b, func(loc uint) bool {
+		present = atomic.LoadUint64(&f.words[loc/64])&(1<<(loc%64)) != 0
+		return present
+	})
+	return present
+}
@@ -0,0 +1,66 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+)
+
+func TestAtomicBasic(t *testing.T) {
+	f := NewAtomic(1000, 4)
+	if f.Cap() != 1000 || f.K() != 4 {
+		t.Errorf("Expected m=1000, k=4, got m=%v, k=%v", f.Cap(), f.K())
+	}
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	f.Add(n1)
+	if !f.Test(n1) {
+		t.Errorf("%v should be in.", n1)
+	}
+	if f.Test(n2) {
+		t.Errorf("%v should not be in.", n2)
+	}
+}
+
+func TestAtomicLocatesLikeNew(t *testing.T) {
+	f := NewAtomic(1000, 4)
+	g := New(1000, 4)
+	n1 := make([]byte, 4)
+	for i := uint32(0); i < 100; i++ {
+		binary.BigEndian.PutUint32(n1, i)
+		f.Add(n1)
+		g.Add(n1)
+	}
+	for i := uint(0); i < 1000; i++ {
+		if (f.words[i/64]&(1<<(i%64)) != 0) != g.b.Test(i) {
+			t.Fatalf("Bit %v differs from New", i)
+		}
+	}
+}
+
+func TestAtomicConcurrent(t *testing.T) {
+	f := NewAtomic(100000, 5)
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			n1 := make([]byte, 4)
+			for i := 0; i < 1000; i++ {
+				binary.BigEndian.PutUint32(n1, uint32(g*1000+i))
+				f.Add(n1)
+				if !f.Test(n1) {
+					t.Errorf("%v should be in right after Add.", n1)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	n1 := make([]byte, 4)
+	for i := 0; i < 16000; i++ {
+		binary.BigEndian.PutUint32(n1, uint32(i))
+		if !f.Test(n1) {
+			t.Errorf("%v should be in.", n1)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+func TestBlockedBasic(t *testing.T) {
+	f := NewBlocked(1000, 4)
+	if f.Cap() != 1024 || f.K() != 4 {
+		t.Errorf("Expected m=1024, k=4, got m=%v, k=%v", f.Cap(), f.K())
+	}
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	f.Add(n1)
+	if !f.Test(n1) {
+		t.Errorf("%v should be in.", n1)
+	}
+	if f.Test(n2) {
+		t.Errorf("%v should not be in.", n2)
+	}
+	f.ClearAll()
+	if f.Test(n1) {
+		t.Errorf("%v should not be in after ClearAll.", n1)
+	}
+}
+
+func TestBlockedOneBlockPerKey(t *testing.T) {
+	f := NewBlocked(100*blockBits, 8)
+	n1 := make([]byte, 4)
+	for i := uint32(0); i < 1000; i++ {
+		binary.BigEndian.PutUint32(n1, i)
+		block := uint(0)
+		j := 0
+		f.forEachLocation(n1, func(loc uint) bool {
+			if j == 0 {
+				block = loc / blockBits
+			} else if loc/blockBits != block {
+				t.Fatalf("%v has locations in blocks %v and %v", n1, block, loc/blockBits)
+			}
+			j++
+			return true
+		})
+		if j != 8 {
+			t.Errorf("%v should have 8 locations, got %v", n1, j)
+		}
+	}
+}
+
+func TestBlockedFalsePositiveRate(t *testing.T) {
+	n, fp := uint(10000), 0.01
+	m, k := EstimateParameters(n, fp)
+	f := NewBlocked(m, k)
+	r := rand.New(rand.NewSource(1))
+	key := make([]byte, 8)
+	for i := uint(0); i < n; i++ {
+		r.Read(key)
+		f.Add(key)
+		if !f.Test(key) {
+			t.Fatalf("%v should be in.", key)
+		}
+	}
+	fps := 0
+	for i := 0; i < 100000; i++ {
+		r.Read(key)
+		if f.Test(key) {
+			fps++
+		}
+	}
+	// somewhat worse than a standard filter, but not by much
+	if rate := float64(fps) / 100000; rate > 2*fp {
+		t.Errorf("False positive rate too high: %v, expected about %v", rate, fp)
+	}
+}
+
+// random keys, so that neither filter benefits from the locality of
+// sequential keys
+func benchmarkKeys(n int) [][]byte {
+	r := rand.New(rand.NewSource(1))
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = make([]byte, 8)
+		r.Read(keys[i])
+	}
+	return keys
+}
+
+// Test keys that are present, so every location is looked up; the
+// filters are far larger than the CPU caches
+func BenchmarkBlockedTest(b *testing.B) {
+	b.StopTimer()
+	n := 10000000
+	m, k := EstimateParameters(uint(n), 0.001)
+	f := NewBlocked(m, k)
+	keys := benchmarkKeys(1 << 16)
+	for _, key := range keys {
+		f.Add(key)
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		f.Test(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkStandardTest(b *testing.B) {
+	b.StopTimer()
+	n := 10000000
+	f := NewWithEstimates(uint(n), 0.001)
+	keys := benchmarkKeys(1 << 16)
+	for _, key := range keys {
+		f.Add(key)
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		f.Test(keys[i%len(keys)])
+	}
+}
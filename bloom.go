@@ -7,8 +7,8 @@ member of a set.
 
 A Bloom filter has two parameters: _m_, a maximum size (typically a reasonably large
 multiple of the cardinality of the set to represent) and _k_, the number of hashing
-functions on elements of the set. (The actual hashing functions are important, too,
-but this is not a parameter for this implementation). A Bloom filter is backed by
+functions on elements of the set. (The actual hashing functions are important, too;
+FNV is used unless another 64-bit hash is supplied with NewWithHasher). A Bloom filter is backed by
 a BitSet; a key is represented in the filter by setting the bits at each value of the
 hashing functions (modulo _m_). Set membership is done by _testing_ whether the
 bits at each value of the hashing functions (again, modulo _m_) are set. If so,
@@ -21,7 +21,12 @@ hashing function which is part of the Go package (hash/fnv). For a item, the
 64-bit FNV hash is computed, and upper and lower 32 bit numbers, call them h1 and
 h2, are used. Then, the _i_th hashing function is:
 
-    h1 + h2*i
+    h1 + h2*i + (i*i*i - i)/6
+
+The cubic term (enhanced double hashing) keeps the _k_ locations from
+falling into short cycles, which plain h1 + h2*i does when h2 shares a
+factor with _m_, and which otherwise raises the false positive rate at
+large _k_.
 
 Thus, the underlying hash function, FNV, is only called once per key.
 
@@ -36,6 +41,11 @@ Similarly, to test if "Love" is in bloom:
 
     if filter.Test([]byte("Love"))
 
+A key is its bytes, so a nil key and an empty one are the same key, and
+AddString("") adds it too. To tell "no key" apart from an empty one, give
+every key a prefix, e.g. a byte 1 ahead of present keys, and use a key of
+its own for the absent case.
+
 For numeric data, I recommend that you look into the binary/encoding library. But,
 for example, to add a uint32 to the filter:
 
@@ -49,39 +59,296 @@ bloom filter for a set of size _n_:
 
     if filter.EstimateFalsePositiveRate(1000) > 0.001
 
-Given the particular hashing scheme, it's best to be empirical about this. Note
-that estimating the FP rate will clear the Bloom filter.
+Given the particular hashing scheme, it's best to be empirical about this. The
+estimate is made on an empty copy of the filter, so its keys are preserved.
+
+The theoretical rate for _n_ entries, (1 - e^(-kn/m))^k, is available without
+touching the filter:
+
+    if filter.FalsePositiveRate(1000) > 0.001
+
+and CurrentFalsePositiveRate() computes it from the bits actually set.
 */
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"github.com/mjarco/bitset"
 	"hash"
 	"hash/fnv"
 	"io"
 	"math"
+	"math/rand"
+	"sync"
 )
 
 type BloomFilter struct {
-	m      uint
-	k      uint
-	b      *bitset.BitSet
-	hasher hash.Hash64
+	m       uint
+	k       uint
+	b       *bitset.BitSet
+	hashers *sync.Pool       // of hash.Hash64, Reset before use
+	seed    []byte           // hashed ahead of every key, when set
+	mask    uint             // m-1 when m is a power of two, 0 otherwise
+	added   uint             // number of keys added
+	novel   uint             // number of keys added that set a new bit
+	frozen  bool             // set by Freeze, rejects changes
+	mu      *sync.RWMutex    // guards adding and testing keys, when set
+	order   binary.ByteOrder // of integer keys, big-endian when nil
 }
 
-// Create a new Bloom filter with _m_ bits and _k_ hashing functions
+// The operations of a Bloom filter on byte keys, for code that takes a
+// filter as a dependency and tests that mock it. It is not named Filter,
+// which is the generic filter of typed keys. Only *BloomFilter
+// implements it: the other filter types return themselves from Add.
+type Interface interface {
+	Add([]byte) *BloomFilter
+	Test([]byte) bool
+	TestAndAdd([]byte) bool
+	Cap() uint
+	K() uint
+}
+
+var _ Interface = (*BloomFilter)(nil)
+
+// Create a new Bloom filter with _m_ bits and _k_ hashing functions.
+// A filter needs at least one bit and one hashing function, so zero
+// values of _m_ or _k_ are taken as 1.
 func New(m uint, k uint) *BloomFilter {
-	return &BloomFilter{m, k, bitset.New(uint(m)), fnv.New64()}
+	return NewWithHasher(m, k, fnv.New64)
+}
+
+// Create a new Bloom filter with _m_ bits and _k_ hashing functions
+// whose mapping from key bytes to bit positions is fixed, on every
+// platform and in every version, so that golden tests and other
+// implementations can rely on it. It is that of New, spelled out here:
+// h is the 64-bit FNV-1 hash of the key, with no seed; a and b are its
+// lower and upper 32 bits; x starts at a mod m and y at b mod m, and
+// for i from 0 to k-1 location i is x, after which x becomes (x+y) mod m
+// and y becomes (y+i+1) mod m. Above 2^32 bits a and b are instead the
+// murmur3 fmix64 of FNV-1(key) and of FNV-1(key, 0x9e, key), unsplit.
+func NewDeterministic(m uint, k uint) *BloomFilter {
+	return NewWithHasher(m, k, fnv.New64)
+}
+
+// Create a new Bloom filter with _m_ bits and _k_ hashing functions,
+// hashing keys with the 64-bit hashes made by h. Hashers are pooled
+// and never shared between concurrent calls, so h must return a new
+// instance on each call.
+func NewWithHasher(m uint, k uint, h func() hash.Hash64) *BloomFilter {
+	if m == 0 {
+		m = 1
+	}
+	if k == 0 {
+		k = 1
+	}
+	return &BloomFilter{
+		m:       m,
+		k:       k,
+		b:       bitset.New(m),
+		hashers: hasherPool(h),
+		mask:    maskFor(m),
+	}
+}
+
+// Create a new Bloom filter with _k_ hashing functions and at least _m_
+// bits, rounding _m_ up to a multiple of 64 so that the bitset has no
+// unused bits in its last word. Keys are located modulo the rounded
+// _m_, which Cap returns, so the filter is compatible with filters made
+// by New with that size. Zero values of _m_ are taken as 1, so give 64.
+func NewAligned(m uint, k uint) *BloomFilter {
+	if m == 0 {
+		m = 1
+	}
+	return New((m+63)/64*64, k)
+}
+
+// make a pool of the hashers made by h
+func hasherPool(h func() hash.Hash64) *sync.Pool {
+	return &sync.Pool{New: func() interface{} { return h() }}
+}
+
+// Create a Bloom filter with _k_ hashing functions backed by b, which
+// is used as is rather than copied; _m_ is the length of b. Returns an
+// error if b is empty or k is 0.
+func NewFromBitSet(b *bitset.BitSet, k uint) (*BloomFilter, error) {
+	if b == nil || b.Len() == 0 {
+		return nil, errors.New("bloom: empty bitset")
+	}
+	if k == 0 {
+		return nil, errors.New("bloom: k must be positive")
+	}
+	return &BloomFilter{
+		m:       b.Len(),
+		k:       k,
+		b:       b,
+		hashers: hasherPool(fnv.New64),
+		mask:    maskFor(b.Len()),
+	}, nil
+}
+
+// Create a new Bloom filter with _k_ hashing functions and at least
+// bits bits, rounding _m_ up to a power of two so that locations are
+// reduced with a mask rather than a modulo. Above the greatest power of
+// two a uint holds, _m_ is that power of two.
+func NewPowerOfTwo(bits uint, k uint) *BloomFilter {
+	return New(powerOfTwoAtLeast(bits), k)
+}
+
+// the greatest power of two a uint holds
+const maxPowerOfTwo = math.MaxUint>>1 + 1
+
+// get the least power of two of at least bits, capped at maxPowerOfTwo
+func powerOfTwoAtLeast(bits uint) uint {
+	if bits > maxPowerOfTwo {
+		return maxPowerOfTwo
+	}
+	m := uint(1)
+	for m < bits {
+		m <<= 1
+	}
+	return m
+}
+
+// get the mask reducing locations modulo m, if m is a power of two
+func maskFor(m uint) uint {
+	if m > 1 && m&(m-1) == 0 {
+		return m - 1
+	}
+	return 0
+}
+
+// Create a new Bloom filter with _m_ bits and _k_ hashing functions,
+// mixing seed into the hash of every key. Filters with different seeds
+// map the same key to independent locations; seed 0 gives the same
+// locations as New. The seed is not stored by Encode.
+func NewWithSeed(m uint, k uint, seed uint) *BloomFilter {
+	f := New(m, k)
+	f.seed = seedBytes(seed)
+	return f
+}
+
+// get the bytes hashed ahead of every key for seed, none for 0
+func seedBytes(seed uint) []byte {
+	if seed == 0 {
+		return nil
+	}
+	b := make([]byte, 8)
+	wireOrder.PutUint64(b, uint64(seed))
+	return b
+}
+
+// the byte order of seeds and of the fixed-size fields the package
+// writes, such as the checksum of EncodeChecked. It is fixed so that
+// filters agree between platforms and versions; only integer keys can
+// be encoded in another order, with WithByteOrder. Varints have no byte
+// order, and the words of the bitset are written big-endian by bitset.
+var wireOrder = binary.BigEndian
+
+// Encode the integer keys of AddUint64, TestUint64, AddInt and TestInt
+// in order, to match keys encoded by other systems, rather than
+// big-endian. The order is a property of the keys, like the hasher, so
+// it is not stored by Encode, and filters must agree on it to be
+// combined. Returns the filter (allows chaining)
+func (f *BloomFilter) WithByteOrder(order binary.ByteOrder) *BloomFilter {
+	f.order = order
+	return f
+}
+
+// get the byte order of integer keys
+func (f *BloomFilter) byteOrder() binary.ByteOrder {
+	if f.order == nil {
+		return binary.BigEndian
+	}
+	return f.order
 }
 
 // Estimate parameters. Based on https://bitbucket.org/ww/bloom/src/829aa19d01d9/bloom.go
 // used with permission.
+// m is rounded up, and then grown to the smallest size at which the
+// (integer) k meets p, so the theoretical false positive rate of the
+// result never exceeds p.
+//
+// Degenerate arguments still give a usable filter: n of 0 is taken as 1,
+// a p of 1 or more, or NaN, which any filter meets, gives the smallest
+// filter, m=1 and k=1, and a p of 0 or below, which none does, is taken
+// as 1e-12, as in MinimalMForExactish. A p below 2^-1022, the smallest
+// normal float64, is taken as 2^-1022: the rates of larger filters
+// underflow below it, so none could be found to meet it.
+// NewWithEstimatesChecked rejects degenerate arguments instead.
 func EstimateParameters(n uint, p float64) (m uint, k uint) {
-	m = uint(-1 * float64(n) * math.Log(p) / math.Pow(math.Log(2), 2))
+	n, p, ok := clampEstimate(n, p)
+	if !ok {
+		return 1, 1
+	}
+	m = OptimalM(n, p)
 	k = uint(math.Ceil(math.Log(2) * float64(m) / float64(n)))
+	if k < 1 {
+		k = 1
+	}
+	// (1 - e^(-k*n/m))^k <= p  <=>  m >= -k*n / ln(1 - p^(1/k))
+	exact := uint(math.Ceil(-float64(k) * float64(n) / math.Log1p(-math.Pow(p, 1/float64(k)))))
+	if exact > m {
+		m = exact
+	}
+	for p > 0 && falsePositiveRate(m, k, n) > p {
+		m++
+	}
 	return
 }
 
+// Return the number of bits, -n*ln(fp) / ln(2)^2 rounded up, at which
+// a Bloom filter for n items with the optimal number of hashing
+// functions has fp false positive rate. The optimal k is rarely an
+// integer, so EstimateParameters grows this to meet fp exactly.
+// Degenerate arguments are taken as by EstimateParameters, an fp of 1 or
+// more, or NaN, giving 1.
+func OptimalM(n uint, fp float64) uint {
+	n, fp, ok := clampEstimate(n, fp)
+	if !ok {
+		return 1
+	}
+	return uint(math.Ceil(-1 * float64(n) * math.Log(fp) / math.Pow(math.Log(2), 2)))
+}
+
+// take n of 0 as 1, an fp of 0 or below, which no filter meets, as
+// 1e-12, and a positive fp below minFP as minFP; ok is false for an fp of 1 or more, or NaN, which any filter
+// meets, so that the smallest filter will do
+func clampEstimate(n uint, fp float64) (uint, float64, bool) {
+	if n == 0 {
+		n = 1
+	}
+	switch {
+	case math.IsNaN(fp) || fp >= 1:
+		return n, fp, false
+	case fp <= 0:
+		fp = exactishFP
+	case fp < minFP:
+		fp = minFP
+	}
+	return n, fp, true
+}
+
+// the smallest normal float64, below which false positive rates lose
+// precision, so that growing m no longer lowers them
+const minFP = 0x1p-1022
+
+// Return the number of hashing functions, round(ln2 * m/n), minimising
+// the false positive rate of a Bloom filter of m bits holding n items.
+// It is at least 1, and n of 0 is taken as 1.
+func OptimalK(m uint, n uint) uint {
+	if n == 0 {
+		n = 1
+	}
+	k := uint(math.Floor(math.Log(2)*float64(m)/float64(n) + 0.5))
+	if k < 1 {
+		k = 1
+	}
+	return k
+}
+
 // Create a new Bloom filter for about n items with fp
 // false positive rate
 func NewWithEstimates(n uint, fp float64) *BloomFilter {
@@ -89,90 +356,1290 @@ func NewWithEstimates(n uint, fp float64) *BloomFilter {
 	return New(m, k)
 }
 
+// Create the Bloom filter for about n items with the lowest false
+// positive rate that fits in maxBytes bytes: _m_ is maxBytes*8 and _k_
+// is OptimalK(m, n). FalsePositiveRate(n) gives the resulting rate.
+func NewWithMaxBytes(maxBytes uint, n uint) *BloomFilter {
+	m := maxBytes * 8
+	return New(m, OptimalK(m, n))
+}
+
+// the false positive rate of MinimalMForExactish
+const exactishFP = 1e-12
+
+// Return the parameters, as EstimateParameters gives them, of a Bloom
+// filter for n items (at least 1) with a false positive rate of 1e-12,
+// so that no false positive should ever be seen in practice: some 58
+// bits per item and 40 hashing functions. A Bloom filter is never exact,
+// however large; use a set where a false positive cannot be tolerated.
+func MinimalMForExactish(n uint) (m uint, k uint) {
+	if n == 0 {
+		n = 1
+	}
+	return EstimateParameters(n, exactishFP)
+}
+
+// Recommend parameters for a Bloom filter of n items with fp false
+// positive rate: _m_ and _k_ as EstimateParameters gives them, the bytes
+// the bits take, (m+7)/8 as ByteSize gives them, and the false positive
+// rate those integer parameters actually give for n items, which is at
+// most fp and often below it.
+func Advise(n uint, fp float64) (m, k uint, bytes uint, realizedFP float64) {
+	m, k = EstimateParameters(n, fp)
+	return m, k, (m + 7) / 8, falsePositiveRate(m, k, n)
+}
+
+// Create a new Bloom filter for about n items with fp false positive
+// rate, returning an error unless n > 0 and 0 < fp < 1
+func NewWithEstimatesChecked(n uint, fp float64) (*BloomFilter, error) {
+	if n == 0 {
+		return nil, errors.New("bloom: number of items must be positive")
+	}
+	if !(fp > 0 && fp < 1) {
+		return nil, fmt.Errorf("bloom: false positive rate %v is not between 0 and 1", fp)
+	}
+	return NewWithEstimates(n, fp), nil
+}
+
 // Return the capacity, _m_, of a Bloom filter
 func (b *BloomFilter) Cap() uint {
 	return b.m
 }
 
+// Return the number of bytes needed to hold the _m_ bits of a Bloom
+// filter, i.e. Cap()/8 rounded up. The bitset allocates whole 64-bit
+// words, so it may take up to 7 bytes more, besides a few words of
+// fixed overhead per filter.
+func (f *BloomFilter) ByteSize() uint {
+	return (f.m + 7) / 8
+}
+
 // Return the number of hash functions used
 func (b *BloomFilter) K() uint {
 	return b.k
 }
 
+// Describe the Bloom filter by its parameters, e.g. "BloomFilter{m: 20000, k: 7}".
+// This stays cheap by not counting the bits set; see Stats for that.
+func (f *BloomFilter) String() string {
+	return fmt.Sprintf("BloomFilter{m: %v, k: %v}", f.m, f.k)
+}
+
+// get a hasher from the pool, ready for a key to be written to it
+func (f *BloomFilter) hasher() hash.Hash64 {
+	h := f.hashers.Get().(hash.Hash64)
+	h.Reset()
+	if f.seed != nil {
+		h.Write(f.seed)
+	}
+	return h
+}
+
+// Discard the hashers the Bloom filter keeps for reuse, so that new
+// ones are made from its hash function. Hashers are Reset before every
+// key, and one in use when a call panics is never reused, so this is
+// only a defence against hashers whose Reset does not clear all their
+// state; locations are unchanged.
+func (f *BloomFilter) ResetHasher() {
+	f.hashers = &sync.Pool{New: f.hashers.New}
+}
+
+// return h to the pool, splitting its sum into the two base hashes
+func (f *BloomFilter) release(h hash.Hash64) (a uint32, b uint32) {
+	sum := h.Sum64()
+	f.hashers.Put(h)
+	return uint32(sum), uint32(sum >> 32)
+}
+
 // get the two basic hash function values for data
 func (f *BloomFilter) base_hashes(data []byte) (a uint32, b uint32) {
-	f.hasher.Reset()
-	//	f.hasher.Write(data)
-	sum := f.hasher.Sum(data)
-	upper := sum[0:4]
-	lower := sum[4:8]
-	a = binary.BigEndian.Uint32(lower)
-	b = binary.BigEndian.Uint32(upper)
-	return
+	h := f.hasher()
+	h.Write(data)
+	return f.release(h)
+}
+
+// written between the two rounds of wide_hashes
+var wideSalt = []byte{0x9e}
+
+// get two 64-bit hash values for data, for filters too large to be
+// addressed by 32-bit base hashes: the digest of data, and the digest
+// after hashing a salt byte and data a second time. Both are mixed, as
+// the modulo of a large _m_ depends on high bits that FNV barely
+// changes between keys differing in their last byte. 128-bit hashers
+// give two 64-bit values at once, which are used as they are.
+func (f *BloomFilter) wide_hashes(data []byte) (a uint64, b uint64) {
+	h := f.hasher()
+	h.Write(data)
+	if h128, ok := h.(hash128); ok {
+		a, b = h128.Sum128()
+		f.hashers.Put(h)
+		return
+	}
+	a = h.Sum64()
+	h.Write(wideSalt)
+	h.Write(data)
+	b = h.Sum64()
+	f.hashers.Put(h)
+	return mix64(a), mix64(b)
+}
+
+// the murmur3 64-bit finalizer, spreading every input bit over the output
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// get the hash values locating data: the base hashes, or the wide
+// hashes when _m_ does not fit in 32 bits
+func (f *BloomFilter) key_hashes(data []byte) (a uint64, b uint64) {
+	if f.m > math.MaxUint32 {
+		return f.wide_hashes(data)
+	}
+	h := f.hasher()
+	h.Write(data)
+	return f.sum(h)
+}
+
+// return h to the pool, taking the hash values locating the key written
+// to it: the base hashes, or both halves of the hash of 128-bit hashers
+func (f *BloomFilter) sum(h hash.Hash64) (a uint64, b uint64) {
+	if h128, ok := h.(hash128); ok {
+		a, b = h128.Sum128()
+		f.hashers.Put(h)
+		return
+	}
+	a32, b32 := f.release(h)
+	return uint64(a32), uint64(b32)
+}
+
+// get the hash values locating s, as key_hashes, without converting it
+// to a []byte when the hasher implements io.StringWriter
+func (f *BloomFilter) string_hashes(s string) (a uint64, b uint64) {
+	if f.m > math.MaxUint32 {
+		return f.wide_hashes([]byte(s))
+	}
+	h := f.hasher()
+	io.WriteString(h, s)
+	return f.sum(h)
+}
+
+// get the hash values locating the content of r, as key_hashes. The
+// wide hashes hash the content twice, so it is read into memory when
+// _m_ does not fit in 32 bits; otherwise it is streamed.
+func (f *BloomFilter) reader_hashes(r io.Reader) (a uint64, b uint64, err error) {
+	if f.m > math.MaxUint32 {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return 0, 0, err
+		}
+		a, b = f.wide_hashes(data)
+		return a, b, nil
+	}
+	h := f.hasher()
+	if _, err := io.Copy(h, r); err != nil {
+		f.hashers.Put(h)
+		return 0, 0, err
+	}
+	a, b = f.sum(h)
+	return a, b, nil
+}
+
+// call fn with each of the _k_ locations of data in the underlying
+// bitset, stopping early if fn returns false; unlike locations this
+// does not allocate
+func (f *BloomFilter) forEachLocation(data []byte, fn func(loc uint) bool) {
+	a, b := f.key_hashes(data)
+	f.forEachHashLocation(a, b, fn)
+}
+
+// call fn with each of the _k_ locations derived from the base hashes
+// a and b, stopping early if fn returns false. The _i_th location is
+// a + b*i + (i*i*i - i)/6 (enhanced double hashing), computed
+// incrementally modulo _m_.
+func (f *BloomFilter) forEachHashLocation(a uint64, b uint64, fn func(loc uint) bool) {
+	if f.mask != 0 {
+		x, y := uint(a)&f.mask, uint(b)&f.mask
+		for i := uint(0); i < f.k; i++ {
+			if !fn(x) {
+				return
+			}
+			x = (x + y) & f.mask
+			y = (y + i + 1) & f.mask
+		}
+		return
+	}
+	x, y := uint(a%uint64(f.m)), uint(b%uint64(f.m))
+	for i := uint(0); i < f.k; i++ {
+		if !fn(x) {
+			return
+		}
+		x = (x + y) % f.m
+		y = (y + i + 1) % f.m
+	}
 }
 
 // get the _k_ locations to set/test in the underlying bitset
 func (f *BloomFilter) locations(data []byte) (locs []uint) {
-	locs = make([]uint, f.k)
-	a, b := f.base_hashes(data)
-	ua := uint(a)
-	ub := uint(b)
-	m := uint(f.m)
-	k := uint(f.k)
+	locs = make([]uint, 0, f.k)
+	f.forEachLocation(data, func(loc uint) bool {
+		locs = append(locs, loc)
+		return true
+	})
+	return
+}
+
+// Return the _k_ locations data maps to in the filter's bitset. They
+// are the same in every filter with the same _m_, _k_, hasher and seed.
+func (f *BloomFilter) Locations(data []byte) []uint {
+	return f.locations(data)
+}
+
+// get the two basic hash function values for data using hasher
+func base_hashes(hasher hash.Hash64, data []byte) (a uint32, b uint32) {
+	hasher.Reset()
+	hasher.Write(data)
+	sum := hasher.Sum64()
+	return uint32(sum), uint32(sum >> 32)
+}
+
+// get the _k_ locations below _m_ that data maps to using hasher;
+// shared by the other filter types of the package. There are none
+// when m is 0.
+func locations(hasher hash.Hash64, data []byte, m uint, k uint) (locs []uint) {
+	if m == 0 {
+		return nil
+	}
+	locs = make([]uint, k)
+	a, b := base_hashes(hasher, data)
+	x, y := uint(a)%m, uint(b)%m
 	for i := uint(0); i < k; i++ {
-		locs[i] = (ua + ub*i) % m
+		locs[i] = x
+		x = (x + y) % m
+		y = (y + i + 1) % m
 	}
 	return
 }
 
+// set the locations derived from the hash values a and b, returning
+// how many of them were not set before
+func (f *BloomFilter) addHashes(a uint64, b uint64) (set int) {
+	f.mutable()
+	if f.mu != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+	}
+	return f.setHashes(a, b)
+}
+
+// set the locations derived from a and b as addHashes does, under the
+// write lock of a concurrent filter
+func (f *BloomFilter) setHashes(a uint64, b uint64) (set int) {
+	f.forEachHashLocation(a, b, func(loc uint) bool {
+		if !f.b.Test(loc) {
+			f.b.Set(loc)
+			set++
+		}
+		return true
+	})
+	f.added++
+	if set > 0 {
+		f.novel++
+	}
+	return
+}
+
+// test the locations derived from the hash values a and b
+func (f *BloomFilter) testHashes(a uint64, b uint64) bool {
+	if f.mu != nil {
+		f.mu.RLock()
+		defer f.mu.RUnlock()
+	}
+	return f.hasHashes(a, b)
+}
+
+// test the locations derived from a and b as testHashes does, under
+// the lock of a concurrent filter
+func (f *BloomFilter) hasHashes(a uint64, b uint64) bool {
+	present := true
+	f.forEachHashLocation(a, b, func(loc uint) bool {
+		present = f.b.Test(loc)
+		return present
+	})
+	return present
+}
+
 // Add data to the Bloom Filter. Returns the filter (allows chaining)
 func (f *BloomFilter) Add(data []byte) *BloomFilter {
-	for _, loc := range f.locations(data) {
-		f.b.Set(loc)
-	}
+	f.addHashes(f.key_hashes(data))
 	return f
 }
 
+// Add data to the Bloom filter, returning whether this set a bit that
+// was not set before, i.e. whether data was very likely new
+func (f *BloomFilter) AddChanged(data []byte) bool {
+	return f.addHashes(f.key_hashes(data)) > 0
+}
+
+// Add data to the Bloom filter, returning how many of its _k_ locations
+// were not set before, from 0 for a probable duplicate to _k_ for a key
+// that is certainly new. A location that data maps to twice counts once.
+func (f *BloomFilter) AddCountingNewBits(data []byte) int {
+	return f.addHashes(f.key_hashes(data))
+}
+
 // Tests for the presence of data in the Bloom filter
 func (f *BloomFilter) Test(data []byte) bool {
-	for _, loc := range f.locations(data) {
+	return f.testHashes(f.key_hashes(data))
+}
+
+// Tests for the presence of data in the Bloom filter, as Test, also
+// returning the index among its _k_ locations of the first one that is
+// not set, or -1 if all of them are set
+func (f *BloomFilter) TestVerbose(data []byte) (bool, int) {
+	a, b := f.key_hashes(data)
+	if f.mu != nil {
+		f.mu.RLock()
+		defer f.mu.RUnlock()
+	}
+	index, i := -1, 0
+	f.forEachHashLocation(a, b, func(loc uint) bool {
 		if !f.b.Test(loc) {
+			index = i
 			return false
 		}
+		i++
+		return true
+	})
+	return index < 0, index
+}
+
+// Tests for the presence of data in the Bloom filter; the same as Test
+func (f *BloomFilter) Contains(data []byte) bool {
+	return f.Test(data)
+}
+
+// Add the content of r to the Bloom filter, hashing it as it is read
+// rather than buffering it; the same as Add of the whole content. If
+// reading fails the filter is left unchanged and the error returned.
+// Returns the filter (allows chaining)
+func (f *BloomFilter) AddReader(r io.Reader) (*BloomFilter, error) {
+	a, b, err := f.reader_hashes(r)
+	if err != nil {
+		return f, err
+	}
+	f.addHashes(a, b)
+	return f, nil
+}
+
+// Tests for the presence of the content of r in the Bloom filter; the
+// same as Test of the whole content
+func (f *BloomFilter) TestReader(r io.Reader) (bool, error) {
+	a, b, err := f.reader_hashes(r)
+	if err != nil {
+		return false, err
+	}
+	return f.testHashes(a, b), nil
+}
+
+// Tests for the presence of data in the Bloom filter, then adds it,
+// hashing it only once. Returns whether data was present before. On a
+// concurrent filter the test and the add are made under one lock, so of
+// goroutines adding the same key at once only one finds it absent.
+func (f *BloomFilter) TestAndAdd(data []byte) bool {
+	// data was present if adding it set no new bit, which on a
+	// concurrent filter is decided under a single lock
+	return f.addHashes(f.key_hashes(data)) == 0
+}
+
+// Tests for the presence of data in the Bloom filter, and adds it only
+// if it was absent. Returns whether data was present before. The bits
+// set afterwards are the same as with TestAndAdd, and data tests
+// present after either; but TestAndAdd counts data in Len every time,
+// where TestOrAdd counts only keys that were absent. It is atomic on a
+// concurrent filter, as TestAndAdd is.
+func (f *BloomFilter) TestOrAdd(data []byte) (present bool) {
+	a, b := f.key_hashes(data)
+	if f.mu != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+	}
+	if present = f.hasHashes(a, b); !present {
+		f.mutable()
+		f.setHashes(a, b)
+	}
+	return
+}
+
+// Filter items down to those not yet in the Bloom filter, adding them
+// so that later duplicates, in items or in later calls, are dropped.
+// Each item is hashed once, by TestAndAdd. Items are kept in order, and
+// a false positive drops an item that was never seen. On a concurrent
+// filter, goroutines deduplicating at once keep each item only once
+// between them.
+func (f *BloomFilter) Dedup(items [][]byte) (unseen [][]byte) {
+	for _, data := range items {
+		if !f.TestAndAdd(data) {
+			unseen = append(unseen, data)
+		}
+	}
+	return
+}
+
+// Add every item to the Bloom filter. Returns the filter (allows chaining)
+func (f *BloomFilter) AddAll(items [][]byte) *BloomFilter {
+	for _, data := range items {
+		f.Add(data)
+	}
+	return f
+}
+
+// number of keys between checks of the context in the Context methods
+const contextCheckInterval = 4096
+
+// Add every item to the Bloom filter as AddAll does, checking ctx every
+// few thousand items and returning its error as soon as it is done. The
+// items added until then stay in the filter.
+func (f *BloomFilter) AddAllContext(ctx context.Context, items [][]byte) error {
+	for i, data := range items {
+		if i%contextCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		f.Add(data)
+	}
+	return nil
+}
+
+// Add each of the items given to the Bloom filter; the same as
+// AddAll(items). Returns the filter (allows chaining)
+func (f *BloomFilter) AddVariadic(items ...[]byte) *BloomFilter {
+	return f.AddAll(items)
+}
+
+// Add every item received from ch to the Bloom filter, until ch is
+// closed. The filter is only touched from the calling goroutine, so
+// producers can send concurrently without locking. Returns the filter
+// (allows chaining)
+func (f *BloomFilter) AddFromChannel(ch <-chan []byte) *BloomFilter {
+	for data := range ch {
+		f.Add(data)
+	}
+	return f
+}
+
+// Tests for the presence of each item in the Bloom filter, returning
+// the results in the same order
+func (f *BloomFilter) TestAll(items [][]byte) []bool {
+	present := make([]bool, len(items))
+	for i, data := range items {
+		present[i] = f.Test(data)
+	}
+	return present
+}
+
+// Tests for the presence of each item in the Bloom filter as TestAll
+// does, splitting the items between workers goroutines (at least one).
+// This relies on Test being safe for concurrent use, each call hashing
+// with its own hasher from the pool, so the filter must not be modified
+// meanwhile unless it was built Concurrent.
+func (f *BloomFilter) TestAllParallel(items [][]byte, workers int) []bool {
+	if workers < 1 {
+		workers = 1
+	}
+	present := make([]bool, len(items))
+	chunk := (len(items) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(items); start += chunk {
+		end := start + chunk
+		if end > len(items) {
+			end = len(items)
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				present[i] = f.Test(items[i])
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	return present
+}
+
+// Add a string to the Bloom filter; the same as Add([]byte(s)).
+// Returns the filter (allows chaining)
+func (f *BloomFilter) AddString(s string) *BloomFilter {
+	f.addHashes(f.string_hashes(s))
+	return f
+}
+
+// Tests for the presence of a string in the Bloom filter; the same as
+// Test([]byte(s))
+func (f *BloomFilter) TestString(s string) bool {
+	return f.testHashes(f.string_hashes(s))
+}
+
+// Implemented by keys that encode themselves for a Bloom filter. Equal
+// keys must give equal bytes, and distinct keys should give distinct
+// ones, e.g. by length-prefixing the fields of a composite key.
+type Hashable interface {
+	BloomBytes() []byte
+}
+
+// Add a Hashable key to the Bloom filter; the same as
+// Add(h.BloomBytes()). Returns the filter (allows chaining)
+func (f *BloomFilter) AddHashable(h Hashable) *BloomFilter {
+	return f.Add(h.BloomBytes())
+}
+
+// Tests for the presence of a Hashable key in the Bloom filter; the
+// same as Test(h.BloomBytes())
+func (f *BloomFilter) TestHashable(h Hashable) bool {
+	return f.Test(h.BloomBytes())
+}
+
+// The hash values locating a key, from Precompute, so that a key added
+// to or tested in many filters is hashed once. The values do not depend
+// on _k_ or on _m_ itself, only on the hash function, the seed, and
+// whether _m_ fits in 32 bits.
+type PrecomputedKey struct {
+	a, b uint64
+}
+
+// Hash data for AddPrecomputed and TestPrecomputed. The key locates data
+// as Add does in f, and in any filter made with the same hash function
+// and seed whose _m_ is on the same side of 2^32 as f's
+func (f *BloomFilter) Precompute(data []byte) PrecomputedKey {
+	a, b := f.key_hashes(data)
+	return PrecomputedKey{a, b}
+}
+
+// Add a key hashed by Precompute to the Bloom filter. Returns the filter
+// (allows chaining)
+func (f *BloomFilter) AddPrecomputed(key PrecomputedKey) *BloomFilter {
+	f.addHashes(key.a, key.b)
+	return f
+}
+
+// Tests for the presence of a key hashed by Precompute in the Bloom
+// filter
+func (f *BloomFilter) TestPrecomputed(key PrecomputedKey) bool {
+	return f.testHashes(key.a, key.b)
+}
+
+// scratch buffers encoding integer keys, pooled so that they are not
+// allocated for every key
+var keyBuffers = sync.Pool{New: func() interface{} { return new([8]byte) }}
+
+// get the hash values locating the 8 bytes of u in the filter's byte
+// order, as key_hashes
+func (f *BloomFilter) uint64_hashes(u uint64) (a uint64, b uint64) {
+	buf := keyBuffers.Get().(*[8]byte)
+	f.byteOrder().PutUint64(buf[:], u)
+	a, b = f.key_hashes(buf[:])
+	keyBuffers.Put(buf)
+	return
+}
+
+// Add a uint64 to the Bloom filter, encoded as 8 bytes, big-endian
+// unless set otherwise by WithByteOrder; the same as Add of that
+// encoding, as made by Uint64Key, but without allocating. Returns the
+// filter (allows chaining)
+func (f *BloomFilter) AddUint64(u uint64) *BloomFilter {
+	f.addHashes(f.uint64_hashes(u))
+	return f
+}
+
+// Tests for the presence of a uint64 in the Bloom filter, encoded as in
+// AddUint64
+func (f *BloomFilter) TestUint64(u uint64) bool {
+	return f.testHashes(f.uint64_hashes(u))
+}
+
+// Add an int to the Bloom filter; the same as AddUint64(uint64(i)), so
+// that filters agree whatever the size of int. Returns the filter
+// (allows chaining)
+func (f *BloomFilter) AddInt(i int) *BloomFilter {
+	return f.AddUint64(uint64(i))
+}
+
+// Tests for the presence of an int in the Bloom filter, encoded as in
+// AddInt
+func (f *BloomFilter) TestInt(i int) bool {
+	return f.TestUint64(uint64(i))
+}
+
+// Add a key given by its two base hashes: h1 and h2 are the lower and
+// upper halves of a well-mixed 64-bit hash of the key, as computed by
+// the filter's own hasher for Add. Poorly mixed values give poorly
+// distributed locations. AddHashes matches Add only for filters of
+// 64-bit hashers, such as New's, of at most 2^32 bits: filters of
+// 128-bit hashers, such as NewMurmur3's, and larger filters locate keys
+// from two full 64-bit values, which no pair of uint32 reproduces.
+// AddHashes and TestHashes still agree with each other on those.
+// Returns the filter (allows chaining)
+func (f *BloomFilter) AddHashes(h1 uint32, h2 uint32) *BloomFilter {
+	f.addHashes(uint64(h1), uint64(h2))
+	return f
+}
+
+// Tests for the presence of a key given by its two base hashes, as
+// for AddHashes
+func (f *BloomFilter) TestHashes(h1 uint32, h2 uint32) bool {
+	return f.testHashes(uint64(h1), uint64(h2))
+}
+
+// Return the number of keys added to the Bloom filter since it was
+// created, decoded or cleared. Keys added more than once are counted
+// each time, so this is an upper bound on the number of distinct keys;
+// see DistinctLen. The count is not stored by Encode.
+func (f *BloomFilter) Len() uint {
+	if f.mu != nil {
+		f.mu.RLock()
+		defer f.mu.RUnlock()
 	}
-	return true
+	return f.added
+}
+
+// Return the number of keys added to the Bloom filter, as Len, that
+// set at least one bit that was not set before. Adding a key again
+// sets no new bit, so repeats are not counted; neither are new keys
+// whose bits were all set already (false positives), so this tends to
+// underestimate the number of distinct keys as the filter fills.
+func (f *BloomFilter) DistinctLen() uint {
+	if f.mu != nil {
+		f.mu.RLock()
+		defer f.mu.RUnlock()
+	}
+	return f.novel
 }
 
 // Clear all the data in a Bloom filter, removing all keys
 func (f *BloomFilter) ClearAll() *BloomFilter {
+	f.mutable()
+	if f.mu != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+	}
 	f.b.ClearAll()
+	f.added, f.novel = 0, 0
 	return f
 }
 
+// Return how many times each of the _m_ bits is a location of the keys
+// gen(0) to gen(samples-1), to check that a hasher spreads keys evenly:
+// each count should be near samples*k/m, and the sum over the bits of
+// (count - samples*k/m)^2 / (samples*k/m) near _m_ for a chi-square test.
+// The filter itself is not changed.
+func (f *BloomFilter) LocationHistogram(samples int, gen func(i int) []byte) []uint {
+	hits := make([]uint, f.m)
+	for i := 0; i < samples; i++ {
+		f.forEachLocation(gen(i), func(loc uint) bool {
+			hits[loc]++
+			return true
+		})
+	}
+	return hits
+}
+
+// Return how many of the distinct locations of a are also locations of
+// b, from 0 to _k_, to explain a false positive: b tests present if all
+// of its locations are set, and each one it shares with a key added, a,
+// is set by it. The filter itself is not used beyond its parameters.
+func (f *BloomFilter) SharedLocations(a, b []byte) uint {
+	locs := f.locations(b)
+	seen := make(map[uint]bool, len(locs))
+	shared := uint(0)
+	f.forEachLocation(a, func(loc uint) bool {
+		if seen[loc] {
+			return true
+		}
+		seen[loc] = true
+		for _, l := range locs {
+			if l == loc {
+				shared++
+				break
+			}
+		}
+		return true
+	})
+	return shared
+}
+
+// Clear the bits of the Bloom filter in [start, end). Keys with any of
+// their locations in the range no longer test present, and Len is left
+// unchanged. Returns an error if the range is inverted or extends beyond _m_,
+// or ErrFrozen if the filter is frozen.
+func (f *BloomFilter) ClearRange(start, end uint) error {
+	if f.frozen {
+		return ErrFrozen
+	}
+	if start > end || end > f.m {
+		return fmt.Errorf("bloom: range [%v, %v) is not within m = %v", start, end, f.m)
+	}
+	if f.mu != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+	}
+	for i := start; i < end; i++ {
+		f.b.Clear(i)
+	}
+	return nil
+}
+
+var ErrIncompatibleParameters = errors.New("bloom: filters have different m, k or seed")
+
+// check that f and other have the same parameters, so that they map
+// keys to the same locations. The hashers cannot be compared, so they
+// are assumed to be the same.
+func (f *BloomFilter) compatible(other *BloomFilter) error {
+	if f.m != other.m || f.k != other.k || !f.sameHashing(other) {
+		return ErrIncompatibleParameters
+	}
+	return nil
+}
+
+// report whether f and other hash keys to the same values: they have
+// the same seed, and _m_ either both fit in 32 bits or both not
+func (f *BloomFilter) sameHashing(other *BloomFilter) bool {
+	return bytes.Equal(f.seed, other.seed) && (f.m > math.MaxUint32) == (other.m > math.MaxUint32)
+}
+
+// Tests whether every bit set in f is also set in other, i.e. whether
+// every key in f is also in other. Both filters must have the same _m_,
+// _k_ and seed, or ErrIncompatibleParameters is returned.
+func (f *BloomFilter) IsSubsetOf(other *BloomFilter) (bool, error) {
+	if err := f.compatible(other); err != nil {
+		return false, err
+	}
+	ob, _, _ := other.snapshot()
+	if f.mu != nil {
+		f.mu.RLock()
+		defer f.mu.RUnlock()
+	}
+	return f.b.Difference(ob).Count() == 0, nil
+}
+
+// Add the keys of other to the Bloom filter, so that it holds the union
+// of both. Filters with the same _m_ are combined bit by bit. A filter
+// whose _m_ is a multiple of f's is folded in: each location modulo a
+// multiple of _m_ is the location of the same key modulo _m_, so bit p
+// of other is bit p mod m of f. Any other sizes map keys to unrelated
+// locations, and cannot be combined without the keys themselves.
+//
+// Both filters must have the same _k_ and seed, and _m_ either both fit
+// in 32 bits or both not, or ErrIncompatibleParameters is returned and
+// f is left unchanged. The hashers cannot be compared, so they are
+// assumed to be the same. The counts of keys of both are summed. Returns
+// ErrFrozen if f is frozen.
+func (f *BloomFilter) UnionRehash(other *BloomFilter) error {
+	if f.frozen {
+		return ErrFrozen
+	}
+	if f.k != other.k || other.m%f.m != 0 || !f.sameHashing(other) {
+		return ErrIncompatibleParameters
+	}
+	ob, added, novel := other.snapshot()
+	if f.mu != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+	}
+	if other.m == f.m {
+		f.b = f.b.Union(ob)
+	} else {
+		for i := uint(0); i < other.m; i++ {
+			if ob.Test(i) {
+				f.b.Set(i % f.m)
+			}
+		}
+	}
+	f.added += added
+	f.novel += novel
+	return nil
+}
+
+// Return a new Bloom filter holding the keys of all the filters, which
+// must have the same _m_, _k_ and seed: ErrIncompatibleParameters is
+// returned for the first that differs from the first filter. The result
+// has the hasher and seed of the first filter, and the sum of their
+// counts of keys. The bitset of each filter is unioned a word at a time
+// into a copy of the first. There must be at least one filter.
+func UnionAll(filters []*BloomFilter) (*BloomFilter, error) {
+	if len(filters) == 0 {
+		return nil, errors.New("bloom: no filters to union")
+	}
+	for i, g := range filters[1:] {
+		if err := filters[0].compatible(g); err != nil {
+			return nil, fmt.Errorf("%w: filter %d", err, i+1)
+		}
+	}
+	u := filters[0].Copy()
+	for _, g := range filters[1:] {
+		b, added, novel := g.snapshot()
+		u.b = u.b.Union(b)
+		u.added += added
+		u.novel += novel
+	}
+	return u, nil
+}
+
+var ErrFalsePositiveExceeded = errors.New("bloom: false positive rate exceeded")
+
+// Add the keys of other to the Bloom filter, as UnionRehash does for
+// filters of the same size, then check the false positive rate at the
+// merged fill ratio, as CurrentFalsePositiveRate gives it. If it exceeds
+// maxFP the merge is still done, and ErrFalsePositiveExceeded returned,
+// so the caller can decide to rebuild a larger filter. Both filters must
+// have the same _m_, _k_ and seed, or ErrIncompatibleParameters is
+// returned and f is left unchanged. Returns ErrFrozen if f is frozen.
+func (f *BloomFilter) MergeChecked(other *BloomFilter, maxFP float64) error {
+	if f.frozen {
+		return ErrFrozen
+	}
+	if err := f.compatible(other); err != nil {
+		return err
+	}
+	ob, added, novel := other.snapshot()
+	if f.mu != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+	}
+	f.b = f.b.Union(ob)
+	f.added += added
+	f.novel += novel
+	if rate := f.currentRate(f.b.Count()); rate > maxFP {
+		return fmt.Errorf("%w: %v above %v", ErrFalsePositiveExceeded, rate, maxFP)
+	}
+	return nil
+}
+
+// Call fn with the position of each bit set in the Bloom filter, in
+// ascending order, stopping early if fn returns false. This takes time
+// in O(m) however few bits are set. A concurrent filter's bits are
+// copied first, so fn sees them as they were at the call, and may add
+// keys to f.
+func (f *BloomFilter) SetBits(fn func(pos uint) bool) {
+	b, _, _ := f.snapshot()
+	for i := uint(0); i < b.Len(); i++ {
+		if b.Test(i) && !fn(i) {
+			return
+		}
+	}
+}
+
+// get the bitset and counts of keys of f, for combining it with another
+// filter. Those of a concurrent filter are copied under its read lock,
+// so that the locks of two filters are never held at once; others are
+// f's own, and must not be changed.
+func (f *BloomFilter) snapshot() (b *bitset.BitSet, added uint, novel uint) {
+	if f.mu == nil {
+		return f.b, f.added, f.novel
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.b.Clone(), f.added, f.novel
+}
+
+// count the bits set, under the read lock of a concurrent filter
+func (f *BloomFilter) countSet() uint {
+	if f.mu != nil {
+		f.mu.RLock()
+		defer f.mu.RUnlock()
+	}
+	return f.b.Count()
+}
+
+// Reset the Bloom filter to empty, keeping _m_ and _k_; the same as
+// ClearAll. Returns the filter (allows chaining)
+func (f *BloomFilter) Reset() *BloomFilter {
+	return f.ClearAll()
+}
+
+// Reset the Bloom filter to an empty one with _m_ bits and _k_ hashing
+// functions, as made by New, keeping its hasher and seed. This lets
+// pooled filters be reused for different parameters.
+func (f *BloomFilter) ResetWith(m uint, k uint) {
+	f.mutable()
+	if f.mu != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+	}
+	if m == 0 {
+		m = 1
+	}
+	if k == 0 {
+		k = 1
+	}
+	f.m = m
+	f.k = k
+	f.b = bitset.New(m)
+	f.mask = maskFor(m)
+	f.added, f.novel = 0, 0
+}
+
+// Make an independent copy of the Bloom filter, with the same
+// parameters and keys. The copy is never frozen, so a frozen filter can
+// be copied to be changed.
+func (f *BloomFilter) Copy() *BloomFilter {
+	if f.mu != nil {
+		f.mu.RLock()
+		defer f.mu.RUnlock()
+	}
+	c := *f
+	c.b = f.b.Clone()
+	c.frozen = false
+	if f.mu != nil {
+		c.mu = new(sync.RWMutex)
+	}
+	return &c
+}
+
+var ErrFrozen = errors.New("bloom: filter is frozen")
+
+// Mark the Bloom filter read-only: from now on, adding keys to it or
+// clearing it panics with ErrFrozen, and methods returning an error
+// return ErrFrozen instead of changing it. Testing keys never changes a
+// filter and is safe for concurrent use, so a frozen filter can be
+// shared between goroutines without locking. There is no way back; use
+// Copy to get a filter that can be changed.
+func (f *BloomFilter) Freeze() {
+	f.frozen = true
+}
+
+// Tests whether the Bloom filter was frozen by Freeze
+func (f *BloomFilter) Frozen() bool {
+	return f.frozen
+}
+
+// panic with ErrFrozen if the filter is frozen, before changing it
+func (f *BloomFilter) mutable() {
+	if f.frozen {
+		panic(ErrFrozen)
+	}
+}
+
 // Estimate, for a BloomFilter with a limit of m bytes
 // and k hash functions, what the false positive rate will be
-// whilst storing n entries; runs 10k tests
+// whilst storing n entries. The estimate is made on an empty copy, so
+// the keys stored in f are left untouched. The keys are the 8-byte
+// mix64 of 0 to n-1, and of n onwards for the tests: distinct, the same
+// on every call, and without the structure of sequential integers,
+// which FNV hashes to correlated locations. It runs 10k tests, which
+// SamplesForFP gives for a rate within 10% at 95% confidence only from
+// about 4% up; lower rates are counted from a few false positives, or
+// none below 1e-4. EstimateFalsePositiveRateSeeded with samples of 0
+// tests as many keys as the rate needs, at a cost growing as 1/rate.
 func (f *BloomFilter) EstimateFalsePositiveRate(n uint) (fp_rate float64) {
-	f.ClearAll()
-	n1 := make([]byte, 4)
-	for i := uint32(0); i < uint32(n); i++ {
-		binary.BigEndian.PutUint32(n1, i)
-		f.Add(n1)
+	fp_rate, _ = f.EstimateFalsePositiveRateContext(context.Background(), n)
+	return
+}
+
+// Estimate the false positive rate as EstimateFalsePositiveRate does,
+// checking ctx every few thousand keys and returning its error as soon
+// as it is done
+func (f *BloomFilter) EstimateFalsePositiveRateContext(ctx context.Context, n uint) (float64, error) {
+	e := f.Copy().ClearAll()
+	n1 := make([]byte, 8)
+	for i := uint64(0); i < uint64(n); i++ {
+		if i%contextCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+		}
+		binary.BigEndian.PutUint64(n1, mix64(i))
+		e.Add(n1)
+	}
+	fp := 0
+	for i := 0; i < estimateSamples; i++ {
+		if i%contextCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+		}
+		binary.BigEndian.PutUint64(n1, mix64(uint64(n)+uint64(i)))
+		if e.Test(n1) {
+			fp++
+		}
+	}
+	return float64(fp) / float64(estimateSamples), nil
+}
+
+// Return how many random keys must be tested to estimate a false
+// positive rate of targetFP to within 10% of it, with probability
+// confidence (e.g. 0.95): z^2 (1-p) / (0.1^2 p), z being the normal
+// quantile of the confidence. The count grows as 1/targetFP: some 38000
+// for 1% at 95%, and 100 times that for 0.01%. It is at least 1, and
+// math.MaxInt if it would exceed it or targetFP is not positive.
+func SamplesForFP(targetFP float64, confidence float64) int {
+	if !(targetFP > 0) {
+		return math.MaxInt
+	}
+	if targetFP >= 1 {
+		return 1
+	}
+	z := math.Sqrt2 * math.Erfinv(confidence)
+	samples := math.Ceil(z * z * (1 - targetFP) / (0.01 * targetFP))
+	if !(samples < math.MaxInt) {
+		return math.MaxInt
+	}
+	if samples < 1 {
+		return 1
+	}
+	return int(samples)
+}
+
+// the most keys EstimateFalsePositiveRateSeeded tests by default
+const maxDefaultSamples = 1 << 24
+
+// the keys EstimateFalsePositiveRate tests
+const estimateSamples = 10000
+
+// Estimate the false positive rate of the Bloom filter after n more
+// entries are stored, by adding n random keys to a copy, keys already
+// stored included, and testing samples further random keys. The keys
+// are drawn from a generator seeded with seed, so the estimate is the
+// same for the same filter, n, samples and seed. f is left untouched.
+//
+// A samples of 0 or less tests as many keys as SamplesForFP gives at
+// 95% confidence for the rate at the fill ratio of the copy, up to
+// 2^24; a copy with no bits set has no false positives.
+func (f *BloomFilter) EstimateFalsePositiveRateSeeded(n uint, samples int, seed int64) float64 {
+	c := f.Copy()
+	r := rand.New(rand.NewSource(seed))
+	key := make([]byte, 8)
+	for i := uint(0); i < n; i++ {
+		binary.BigEndian.PutUint64(key, r.Uint64())
+		c.Add(key)
+	}
+	if samples <= 0 {
+		expected := c.CurrentFalsePositiveRate()
+		if expected == 0 {
+			return 0
+		}
+		samples = SamplesForFP(expected, 0.95)
+		if samples > maxDefaultSamples {
+			samples = maxDefaultSamples
+		}
 	}
 	fp := 0
-	// test 10k numbers
-	for i := uint32(0); i < uint32(10000); i++ {
-		binary.BigEndian.PutUint32(n1, i+uint32(n)+1)
-		if f.Test(n1) {
+	for i := 0; i < samples; i++ {
+		binary.BigEndian.PutUint64(key, r.Uint64())
+		if c.Test(key) {
 			fp++
 		}
 	}
-	fp_rate = float64(fp) / float64(100)
-	f.ClearAll()
+	return float64(fp) / float64(samples)
+}
+
+// Compute, for a BloomFilter with m bits and k hash functions, the
+// theoretical false positive rate (1 - e^(-k*n/m))^k after n
+// entries have been stored. Unlike EstimateFalsePositiveRate this
+// does not touch the filter.
+func (f *BloomFilter) FalsePositiveRate(n uint) float64 {
+	return falsePositiveRate(f.m, f.k, n)
+}
+
+// the theoretical false positive rate with m bits, k hash functions
+// and n entries
+func falsePositiveRate(m uint, k uint, n uint) float64 {
+	return math.Pow(1-math.Exp(-float64(k)*float64(n)/float64(m)), float64(k))
+}
+
+// Compute the false positive rate from the current fill ratio of the
+// filter, i.e. the probability that k random bits are all set
+func (f *BloomFilter) CurrentFalsePositiveRate() float64 {
+	return f.currentRate(f.countSet())
+}
+
+// the false positive rate with set bits set
+func (f *BloomFilter) currentRate(set uint) float64 {
+	return math.Pow(float64(set)/float64(f.m), float64(f.k))
+}
+
+// Return whether the false positive rate at the current fill ratio, as
+// CurrentFalsePositiveRate gives it, exceeds targetFP, so that it is time
+// to start a fresh filter
+func (f *BloomFilter) ShouldRotate(targetFP float64) bool {
+	return f.CurrentFalsePositiveRate() > targetFP
+}
+
+// A fill ratio for IsSaturated. A filter with the optimal _k_ for its
+// keys is half full, so beyond this it holds more keys than it was
+// sized for, and its false positive rate grows quickly with every key.
+const DefaultSaturationThreshold = 0.5
+
+// Return whether the fraction of the bits of the Bloom filter that are
+// set exceeds threshold, e.g. DefaultSaturationThreshold, as an early
+// warning that it is filling up. This counts the bits set, so takes time
+// in O(m).
+func (f *BloomFilter) IsSaturated(threshold float64) bool {
+	return float64(f.countSet())/float64(f.m) > threshold
+}
+
+// Diagnostics of a Bloom filter, as returned by Stats
+type Stats struct {
+	M, K           uint
+	SetBits        uint    // number of bits set
+	FillRatio      float64 // SetBits / M
+	EstimatedCount float64 // estimated number of distinct items added
+	CurrentFPRate  float64 // false positive rate at the current fill ratio
+}
+
+// Return diagnostics of the Bloom filter. EstimatedCount follows
+// Swamidass and Baldi, -(m/k) ln(1 - SetBits/m), and is +Inf for a
+// saturated filter. This counts the bits set, so takes time in O(m).
+func (f *BloomFilter) Stats() Stats {
+	set := f.countSet()
+	fill := float64(set) / float64(f.m)
+	return Stats{
+		M:              f.m,
+		K:              f.k,
+		SetBits:        set,
+		FillRatio:      fill,
+		EstimatedCount: estimateCount(f.m, f.k, set),
+		CurrentFPRate:  math.Pow(fill, float64(f.k)),
+	}
+}
+
+// estimate the number of distinct items in a filter with m bits, k
+// hashing functions and set bits set (Swamidass and Baldi)
+func estimateCount(m uint, k uint, set uint) float64 {
+	return -float64(m) / float64(k) * math.Log(1-float64(set)/float64(m))
+}
+
+// Estimate the number of distinct keys added to the Bloom filter from
+// the number of bits set, as EstimatedCount in Stats, rounded to the
+// nearest integer. A saturated filter gives math.MaxUint, as it could
+// hold any number of keys. This counts the bits set, so takes time in
+// O(m).
+func (f *BloomFilter) ApproximateCount() uint {
+	return roundCount(estimateCount(f.m, f.k, f.countSet()))
+}
+
+// Compare the bits per item the Bloom filter uses, _m_ over its
+// estimated count as in Stats, with the fewest bits per item that give
+// its current false positive rate p with the best _k_, -ln(p) / ln(2)^2.
+// They are close for a filter filled to its design capacity; a filter
+// holding many fewer keys, or many more, uses several times the optimum,
+// and should be rebuilt at another size. An empty filter gives +Inf for
+// both, a saturated one 0.
+func (f *BloomFilter) Efficiency() (bitsPerItem float64, optimalBitsPerItem float64) {
+	set := f.countSet()
+	if set == 0 {
+		return math.Inf(1), math.Inf(1)
+	}
+	bitsPerItem = float64(f.m) / estimateCount(f.m, f.k, set)
+	p := math.Pow(float64(set)/float64(f.m), float64(f.k))
+	optimalBitsPerItem = -math.Log(p) / (math.Ln2 * math.Ln2)
 	return
 }
 
+// Return the parameters, as EstimateParameters gives them, of a filter
+// rebuilt to hold the keys of this one at a false positive rate of
+// targetFP. A filter cannot be tightened without its keys; this guides
+// a caller who kept them. The number of keys is ApproximateCount, or
+// Len when the filter is saturated and the count cannot be estimated,
+// and at least 1.
+func (f *BloomFilter) WouldResizeTo(targetFP float64) (newM, newK uint) {
+	n := f.ApproximateCount()
+	if n == math.MaxUint {
+		n = f.Len()
+	}
+	if n == 0 {
+		n = 1
+	}
+	return EstimateParameters(n, targetFP)
+}
+
+// round an estimated count, taking +Inf to math.MaxUint
+func roundCount(n float64) uint {
+	if n >= math.MaxUint {
+		return math.MaxUint
+	}
+	return uint(math.Round(n))
+}
+
+// Estimate the number of distinct keys added to either of a and b, i.e.
+// the size of the union of their sets of keys, as ApproximateCount of
+// their union. The bits set in either are counted in place, without
+// making the union. Both filters must have the same _m_, _k_ and seed,
+// or ErrIncompatibleParameters is returned.
+func EstimateUnionCount(a, b *BloomFilter) (uint, error) {
+	if err := a.compatible(b); err != nil {
+		return 0, err
+	}
+	ab, _, _ := a.snapshot()
+	bb, _, _ := b.snapshot()
+	set := uint(0)
+	for i := uint(0); i < a.m; i++ {
+		if ab.Test(i) || bb.Test(i) {
+			set++
+		}
+	}
+	return roundCount(estimateCount(a.m, a.k, set)), nil
+}
+
+// Estimate the Jaccard index |A ∩ B| / |A ∪ B| of the sets of keys
+// added to f and other, from the bits set in each and in both. The
+// sizes of A, B and A ∪ B are estimated as in Stats, and |A ∩ B| as
+// |A| + |B| - |A ∪ B|. Both filters must have the same _m_, _k_ and
+// seed, or ErrIncompatibleParameters is returned.
+//
+// The estimate degrades as the filters fill: collisions hide items, and
+// it is meaningless once either is saturated. Keep the fill ratio of
+// the union low (say, under one half) for accurate results. The
+// estimate of two empty filters is 0.
+func (f *BloomFilter) EstimateJaccard(other *BloomFilter) (float64, error) {
+	if err := f.compatible(other); err != nil {
+		return 0, err
+	}
+	fb, _, _ := f.snapshot()
+	ob, _, _ := other.snapshot()
+	a, b := fb.Count(), ob.Count()
+	union := a + b - fb.Intersection(ob).Count()
+	if union == 0 {
+		return 0, nil
+	}
+	na := estimateCount(f.m, f.k, a)
+	nb := estimateCount(f.m, f.k, b)
+	nu := estimateCount(f.m, f.k, union)
+	j := (na + nb - nu) / nu
+	return math.Max(0, math.Min(1, j)), nil
+}
+
+// Magic number and format version starting the output of Encode
+var (
+	magic         = []byte("BLM1")
+	formatVersion = byte(1)
+)
+
+var (
+	ErrBadMagic           = errors.New("bloom: not an encoded Bloom filter (bad magic number)")
+	ErrUnsupportedVersion = errors.New("bloom: unsupported format version")
+	ErrTooLarge           = errors.New("bloom: filter exceeds the size limit")
+	ErrLengthMismatch     = errors.New("bloom: bitset length differs from m")
+)
+
+// Write f to w: a magic number and format version, then _m_ and _k_ as
+// varints, then the bitset
 func Encode(w io.Writer, f *BloomFilter) {
+	w.Write(magic)
+	w.Write([]byte{formatVersion})
+	encodeBody(w, f)
+}
+
+// write _m_ and _k_ of f as varints, then its bitset: Encode without
+// the header, as Encode wrote filters before format versions existed
+func encodeBody(w io.Writer, f *BloomFilter) {
+	if f.mu != nil {
+		f.mu.RLock()
+		defer f.mu.RUnlock()
+	}
 	maxsize := 2 * binary.MaxVarintLen64
 	dump := make([]byte, maxsize)
 	//pack m and k
@@ -181,6 +1648,7 @@ func Encode(w io.Writer, f *BloomFilter) {
 	w.Write(dump[0:pos])
 	bitset.Encode(w, f.b)
 }
+
 func one(r io.Reader) (uint64, error) {
 
 	buint := make([]byte, binary.MaxVarintLen64)
@@ -193,16 +1661,111 @@ func one(r io.Reader) (uint64, error) {
 		}
 		ic++
 		decoded, n = binary.Uvarint(buint[:ic])
+		if n < 0 {
+			return 0, errors.New("bloom: varint overflows 64 bits")
+		}
 	}
 	return decoded, nil
 }
-func Decode(r io.Reader) *BloomFilter {
-	m, _ := one(r)        //unpack n
-	k, _ := one(r)        //unpack k
-	b := bitset.Decode(r) //restore bitset
 
-	f := New(uint(m), uint(k)) //create new *BloomFilter value
-	//TODO: check if cannot create bf by hand (and save one bitset creation)
-	f.b = b //replace bitset
-	return f
+// Read a filter written by Encode, checking its magic number and
+// format version. Filters written before format versions existed have
+// no header and are rejected with ErrBadMagic; read them with
+// DecodeLegacy.
+func Decode(r io.Reader) (*BloomFilter, error) {
+	if err := readHeader(r); err != nil {
+		return nil, err
+	}
+	return decodeBody(r, math.MaxUint)
+}
+
+// Read a filter written by Encode, as Decode, rejecting with
+// ErrTooLarge any filter of more than maxBits bits before allocating
+// it. Use this to read filters from untrusted sources, which could
+// otherwise claim a huge size and exhaust memory.
+func DecodeLimited(r io.Reader, maxBits uint) (*BloomFilter, error) {
+	if err := readHeader(r); err != nil {
+		return nil, err
+	}
+	return decodeBody(r, maxBits)
+}
+
+// Read _m_ and _k_ of a filter written by Encode without reading its
+// bits, to catalog many filters cheaply. This consumes the magic number,
+// format version and the two varints of _m_ and _k_, and nothing more:
+// the stream is left at the bitset, so Decode can no longer read the
+// filter from it. Reopen or seek back to the start to decode it.
+func PeekHeader(r io.Reader) (m, k uint, err error) {
+	if err := readHeader(r); err != nil {
+		return 0, 0, err
+	}
+	m64, err := one(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	k64, err := one(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint(m64), uint(k64), nil
+}
+
+// read and check the magic number and format version written by Encode
+func readHeader(r io.Reader) error {
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header[:len(magic)], magic) {
+		return ErrBadMagic
+	}
+	if v := header[len(magic)]; v != formatVersion {
+		return fmt.Errorf("%w %d", ErrUnsupportedVersion, v)
+	}
+	return nil
+}
+
+// read _m_, _k_ and the bitset of a filter, as encodeBody writes them,
+// of at most maxBits bits
+func decodeBody(r io.Reader, maxBits uint) (*BloomFilter, error) {
+	m, err := one(r) //unpack m
+	if err != nil {
+		return nil, err
+	}
+	k, err := one(r) //unpack k
+	if err != nil {
+		return nil, err
+	}
+	if m == 0 || k == 0 {
+		return nil, errors.New("bloom: m and k must be positive")
+	}
+	if m > uint64(maxBits) {
+		return nil, fmt.Errorf("%w: %d bits", ErrTooLarge, m)
+	}
+	// the bitset has its own length, which must be m, checked before
+	// the bitset is allocated
+	length, err := one(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(maxBits) {
+		return nil, fmt.Errorf("%w: %d bits", ErrTooLarge, length)
+	}
+	if length != m {
+		return nil, fmt.Errorf("%w: %d bits for m = %d", ErrLengthMismatch, length, m)
+	}
+	prefix := make([]byte, binary.MaxVarintLen64)
+	prefix = prefix[:binary.PutUvarint(prefix, length)]
+	b := bitset.Decode(io.MultiReader(bytes.NewReader(prefix), r)) //restore bitset
+	if b.Len() != uint(m) {
+		return nil, fmt.Errorf("%w: %d bits for m = %d", ErrLengthMismatch, b.Len(), m)
+	}
+
+	return &BloomFilter{
+		m:       uint(m),
+		k:       uint(k),
+		b:       b,
+		hashers: hasherPool(fnv.New64),
+		mask:    maskFor(uint(m)),
+	}, nil
 }
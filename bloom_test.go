@@ -1,10 +1,21 @@
 package bloom
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
-	//	"fmt"
+	"errors"
+	"fmt"
+	"github.com/mjarco/bitset"
+	"hash"
+	"hash/fnv"
 	"io"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 )
 
 func TestBasic(t *testing.T) {
@@ -42,48 +53,1960 @@ func TestBasicUint32(t *testing.T) {
 	}
 }
 
+// get the highest EstimateFalsePositiveRate likely for a filter of
+// the given theoretical rate: three standard deviations above the mean
+// count of false positives in its 10k tests, plus one for rates so low
+// that the count is 0 or 1
+func maxEstimate(rate float64) float64 {
+	count := rate * estimateSamples
+	return (count + 3*math.Sqrt(count) + 1) / estimateSamples
+}
+
 func TestDirect20_5(t *testing.T) {
 	n := uint(10000)
 	k := uint(5)
 	load := uint(20)
 	f := New(n*load, k)
 	fp_rate := f.EstimateFalsePositiveRate(n)
-	if fp_rate > 0.0001 {
-		t.Errorf("False positive rate too high: load=%v, k=%v, %f", load, k, fp_rate)
+	if rate := f.FalsePositiveRate(n); fp_rate > maxEstimate(rate) {
+		t.Errorf("False positive rate too high: load=%v, k=%v, %f, expected %f", load, k, fp_rate, rate)
+	}
+}
+
+func TestDirect15_10(t *testing.T) {
+	n := uint(10000)
+	k := uint(10)
+	load := uint(15)
+	f := New(n*load, k)
+	fp_rate := f.EstimateFalsePositiveRate(n)
+	if rate := f.FalsePositiveRate(n); fp_rate > maxEstimate(rate) {
+		t.Errorf("False positive rate too high: load=%v, k=%v, %f, expected %f", load, k, fp_rate, rate)
+	}
+}
+
+func TestEstimated10_0001(t *testing.T) {
+	n := uint(10000)
+	fp := 0.0001
+	m, k := EstimateParameters(n, fp)
+	f := NewWithEstimates(n, fp)
+	// EstimateParameters puts the rate just below fp
+	fp_rate := f.EstimateFalsePositiveRate(n)
+	if fp_rate > maxEstimate(fp) {
+		t.Errorf("False positive rate too high: n: %v, fp: %f, n: %v, k: %v result: %f", n, fp, m, k, fp_rate)
+	}
+}
+
+func TestEstimated10_001(t *testing.T) {
+	n := uint(10000)
+	fp := 0.001
+	m, k := EstimateParameters(n, fp)
+	f := NewWithEstimates(n, fp)
+	// EstimateParameters puts the rate just below fp
+	fp_rate := f.EstimateFalsePositiveRate(n)
+	if fp_rate > maxEstimate(fp) {
+		t.Errorf("False positive rate too high: n: %v, fp: %f, n: %v, k: %v result: %f", n, fp, m, k, fp_rate)
+	}
+}
+
+// fill f with n random keys and return the observed false positive
+// rate over a further 100k random keys
+func empiricalFalsePositiveRate(f *BloomFilter, n int) float64 {
+	r := rand.New(rand.NewSource(1))
+	key := make([]byte, 8)
+	for i := 0; i < n; i++ {
+		r.Read(key)
+		f.Add(key)
+	}
+	fp := 0
+	for i := 0; i < 100000; i++ {
+		r.Read(key)
+		if f.Test(key) {
+			fp++
+		}
+	}
+	return float64(fp) / float64(100000)
+}
+
+func TestFalsePositiveRate(t *testing.T) {
+	n := uint(10000)
+	configs := [][2]uint{{20, 5}, {8, 4}, {10, 7}, {5, 3}}
+	for _, c := range configs {
+		load, k := c[0], c[1]
+		f := New(n*load, k)
+		expected := f.FalsePositiveRate(n)
+		if f.b.Count() != 0 {
+			t.Errorf("FalsePositiveRate should not modify the filter")
+		}
+		fp_rate := empiricalFalsePositiveRate(f, int(n))
+		if math.Abs(fp_rate-expected) > 0.25*expected+0.0002 {
+			t.Errorf("Analytic rate too far from empirical: load=%v, k=%v, analytic: %f, empirical: %f", load, k, expected, fp_rate)
+		}
+		current := f.CurrentFalsePositiveRate()
+		if math.Abs(current-expected) > 0.1*expected {
+			t.Errorf("Current rate too far from analytic: load=%v, k=%v, analytic: %f, current: %f", load, k, expected, current)
+		}
+	}
+}
+
+func TestCurrentFalsePositiveRateEmpty(t *testing.T) {
+	f := New(1000, 4)
+	if f.CurrentFalsePositiveRate() != 0 {
+		t.Errorf("Empty filter should have a zero false positive rate")
+	}
+	if f.FalsePositiveRate(0) != 0 {
+		t.Errorf("No entries should give a zero false positive rate")
+	}
+}
+
+func TestShouldRotate(t *testing.T) {
+	n, fp := uint(1000), 0.01
+	f := NewWithEstimates(n, fp)
+	r := rand.New(rand.NewSource(1))
+	n1 := make([]byte, 8)
+	added := uint(0)
+	for !f.ShouldRotate(fp) {
+		if f.CurrentFalsePositiveRate() > fp {
+			t.Fatalf("ShouldRotate should be true at rate %v", f.CurrentFalsePositiveRate())
+		}
+		binary.BigEndian.PutUint64(n1, r.Uint64())
+		f.Add(n1)
+		added++
+	}
+	if f.CurrentFalsePositiveRate() <= fp {
+		t.Errorf("ShouldRotate should be false at rate %v", f.CurrentFalsePositiveRate())
+	}
+	// the filter was sized for n keys at rate fp
+	if added < n*9/10 || added > n*11/10 {
+		t.Errorf("Expected to rotate after about %v keys, got %v", n, added)
+	}
+}
+
+func TestIsSaturated(t *testing.T) {
+	f := NewWithEstimates(1000, 0.01)
+	r := rand.New(rand.NewSource(1))
+	n1 := make([]byte, 8)
+	added := 0
+	for !f.IsSaturated(DefaultSaturationThreshold) {
+		if fill := f.Stats().FillRatio; fill > DefaultSaturationThreshold {
+			t.Fatalf("IsSaturated should be true at fill ratio %v", fill)
+		}
+		binary.BigEndian.PutUint64(n1, r.Uint64())
+		f.Add(n1)
+		added++
+	}
+	if fill := f.Stats().FillRatio; fill <= DefaultSaturationThreshold {
+		t.Errorf("IsSaturated should be false at fill ratio %v", fill)
+	}
+	// a filter with the optimal k is half full at its capacity
+	if added < 900 || added > 1100 {
+		t.Errorf("Expected to saturate after about 1000 keys, got %v", added)
+	}
+	if !f.IsSaturated(0) || f.IsSaturated(1) {
+		t.Errorf("Thresholds of 0 and 1 should always and never trip")
+	}
+}
+
+func TestEstimateFalsePositiveRateKeepsKeys(t *testing.T) {
+	f := New(1000, 4)
+	n1 := []byte("Bess")
+	f.Add(n1)
+	f.EstimateFalsePositiveRate(100)
+	if !f.Test(n1) {
+		t.Errorf("%v should still be in after estimating.", n1)
+	}
+}
+
+func TestEstimateFalsePositiveRateMatchesAnalytic(t *testing.T) {
+	n := uint(10000)
+	f := New(n*4, 3)
+	expected := f.FalsePositiveRate(n)
+	fp_rate := f.EstimateFalsePositiveRate(n)
+	if math.Abs(fp_rate-expected) > 0.25*expected {
+		t.Errorf("Estimated rate too far from analytic: analytic: %f, estimated: %f", expected, fp_rate)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	f := New(1000, 4)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	f.Add(n1)
+	g := f.Copy()
+	g.Add(n2)
+	if g.Cap() != f.Cap() || g.K() != f.K() {
+		t.Errorf("Copy should keep the parameters")
+	}
+	if !g.Test(n1) {
+		t.Errorf("%v should be in the copy.", n1)
+	}
+	if f.Test(n2) {
+		t.Errorf("%v should not be in the original.", n2)
+	}
+}
+
+// a deterministic 64-bit hash (FNV-1a with a final mix) for testing
+// NewWithHasher
+type testHasher struct {
+	sum uint64
+}
+
+func newTestHasher() hash.Hash64 {
+	return &testHasher{14695981039346656037}
+}
+
+func (h *testHasher) Write(b []byte) (int, error) {
+	for _, c := range b {
+		h.sum ^= uint64(c)
+		h.sum *= 1099511628211
+	}
+	return len(b), nil
+}
+
+func (h *testHasher) Sum64() uint64 {
+	x := h.sum
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	return x
+}
+
+func (h *testHasher) Sum(b []byte) []byte {
+	s := make([]byte, 8)
+	binary.BigEndian.PutUint64(s, h.Sum64())
+	return append(b, s...)
+}
+
+func (h *testHasher) Reset()         { h.sum = 14695981039346656037 }
+func (h *testHasher) Size() int      { return 8 }
+func (h *testHasher) BlockSize() int { return 1 }
+
+func TestNewWithHasher(t *testing.T) {
+	calls := 0
+	f := NewWithHasher(1000, 4, func() hash.Hash64 {
+		calls++
+		return newTestHasher()
+	})
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	f.Add(n1)
+	if calls == 0 {
+		t.Errorf("The supplied hasher was not used")
+	}
+	if !f.Test(n1) {
+		t.Errorf("%v should be in.", n1)
+	}
+	if f.Test(n2) {
+		t.Errorf("%v should not be in.", n2)
+	}
+	g := New(1000, 4)
+	g.Add(n1)
+	if f.b.Equal(g.b) {
+		t.Errorf("Different hashers should set different bits")
+	}
+	h := NewWithHasher(10000*8, 4, newTestHasher)
+	expected := h.FalsePositiveRate(10000)
+	fp_rate := empiricalFalsePositiveRate(h, 10000)
+	if fp_rate > 2*expected {
+		t.Errorf("False positive rate too high with custom hasher: %f", fp_rate)
+	}
+}
+
+func TestNewWithSeed(t *testing.T) {
+	f := NewWithSeed(10000, 4, 1)
+	g := NewWithSeed(10000, 4, 2)
+	n1 := make([]byte, 4)
+	for i := uint32(0); i < 1000; i++ {
+		binary.BigEndian.PutUint32(n1, i)
+		f.Add(n1)
+		g.Add(n1)
+	}
+	for i := uint32(0); i < 1000; i++ {
+		binary.BigEndian.PutUint32(n1, i)
+		if !f.Test(n1) || !g.Test(n1) {
+			t.Errorf("%v should be in both filters.", n1)
+		}
+	}
+	// independent bit patterns only overlap by chance
+	fillF := float64(f.b.Count()) / float64(f.m)
+	fillG := float64(g.b.Count()) / float64(g.m)
+	expected := fillF * fillG * float64(f.m)
+	shared := float64(f.b.Intersection(g.b).Count())
+	if shared > 1.2*expected {
+		t.Errorf("Seeded filters share too many bits: %v, expected about %v", shared, expected)
+	}
+	h := NewWithSeed(1000, 4, 0)
+	n1 = []byte("Bess")
+	h.Add(n1)
+	if !h.b.Equal(New(1000, 4).Add(n1).b) {
+		t.Errorf("Seed 0 should hash like New")
+	}
+}
+
+func TestPowerOfTwo(t *testing.T) {
+	f := NewPowerOfTwo(1000, 4)
+	if f.Cap() != 1024 {
+		t.Errorf("Capacity should be rounded up to 1024, got %v", f.Cap())
+	}
+	if NewPowerOfTwo(1024, 4).Cap() != 1024 {
+		t.Errorf("A power of two should not be rounded up")
+	}
+	for bits, m := range map[uint]uint{
+		0:                 1,
+		3:                 4,
+		maxPowerOfTwo - 1: maxPowerOfTwo,
+		maxPowerOfTwo:     maxPowerOfTwo,
+		maxPowerOfTwo + 1: maxPowerOfTwo,
+		math.MaxUint:      maxPowerOfTwo,
+	} {
+		if got := powerOfTwoAtLeast(bits); got != m {
+			t.Errorf("Expected %v bits for %v, got %v", m, bits, got)
+		}
+	}
+	// the mask gives the same locations as the modulo
+	n1 := []byte("Bess")
+	g := New(1024, 4)
+	g.mask = 0
+	want := g.locations(n1)
+	for i, loc := range f.locations(n1) {
+		if loc != want[i] {
+			t.Errorf("Location %v should be %v, got %v", i, want[i], loc)
+		}
+	}
+	f.Add(n1)
+	if !f.Test(n1) {
+		t.Errorf("%v should be in.", n1)
+	}
+	if f.Test([]byte("Jane")) {
+		t.Errorf("Jane should not be in.")
+	}
+}
+
+func TestAddTestDoNotAllocate(t *testing.T) {
+	f := New(1000, 4)
+	n1 := []byte("Bess")
+	f.Add(n1)
+	if allocs := testing.AllocsPerRun(100, func() { f.Add(n1) }); allocs != 0 {
+		t.Errorf("Add should not allocate, got %v allocations", allocs)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { f.Test(n1) }); allocs != 0 {
+		t.Errorf("Test should not allocate, got %v allocations", allocs)
+	}
+}
+
+func TestLocations(t *testing.T) {
+	f := New(1000, 4)
+	n1 := []byte("Bess")
+	locs := f.Locations(n1)
+	if uint(len(locs)) != f.K() {
+		t.Errorf("Expected %v locations, got %v", f.K(), len(locs))
+	}
+	for _, loc := range locs {
+		if loc >= f.Cap() {
+			t.Errorf("Location %v out of range", loc)
+		}
+	}
+	f.Add(n1)
+	for _, loc := range locs {
+		if !f.b.Test(loc) {
+			t.Errorf("Location %v should be set", loc)
+		}
+	}
+}
+
+func TestAddHashes(t *testing.T) {
+	f := New(1000, 4)
+	g := New(1000, 4)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	f.Add(n1)
+	g.AddHashes(g.base_hashes(n1))
+	if !f.b.Equal(g.b) {
+		t.Errorf("Add and AddHashes should set the same bits")
+	}
+	if !g.TestHashes(f.base_hashes(n1)) {
+		t.Errorf("%v should be in.", n1)
+	}
+	if g.TestHashes(f.base_hashes(n2)) {
+		t.Errorf("%v should not be in.", n2)
+	}
+	// a 64-bit hash computed elsewhere gives the same locations
+	h := fnv.New64()
+	h.Write(n1)
+	sum := h.Sum64()
+	if !g.TestHashes(uint32(sum), uint32(sum>>32)) {
+		t.Errorf("%v should be in when hashed by the caller.", n1)
+	}
+	// a 128-bit hasher locates keys from both of its 64-bit halves, which
+	// base hashes cannot give, but AddHashes and TestHashes still agree
+	m := NewMurmur3(1000, 4)
+	m.AddHashes(uint32(sum), uint32(sum>>32))
+	if !m.TestHashes(uint32(sum), uint32(sum>>32)) {
+		t.Errorf("TestHashes should find the hashes given to AddHashes")
+	}
+	if m.Test(n1) {
+		t.Errorf("AddHashes should not match Add on a murmur3 filter")
+	}
+}
+
+func TestAddString(t *testing.T) {
+	f := New(1000, 4)
+	g := New(1000, 4)
+	f.AddString("Bess")
+	g.Add([]byte("Bess"))
+	if !f.b.Equal(g.b) {
+		t.Errorf("AddString and Add should set the same bits")
+	}
+	if !f.Test([]byte("Bess")) || !g.TestString("Bess") {
+		t.Errorf("Bess should be in.")
+	}
+	if f.TestString("Jane") {
+		t.Errorf("Jane should not be in.")
+	}
+	h := NewWithSeed(1000, 4, 7)
+	h.AddString("Bess")
+	if !h.Test([]byte("Bess")) {
+		t.Errorf("Bess should be in a seeded filter.")
+	}
+}
+
+// a composite key, encoded with its fields length-prefixed so that
+// {"ab", "c"} and {"a", "bc"} differ
+type testUserKey struct {
+	tenant, user string
+}
+
+func (u testUserKey) BloomBytes() []byte {
+	b := binary.AppendUvarint(nil, uint64(len(u.tenant)))
+	b = append(b, u.tenant...)
+	return append(b, u.user...)
+}
+
+func TestHashable(t *testing.T) {
+	f := New(1000, 4)
+	k1 := testUserKey{"ab", "c"}
+	f.AddHashable(k1)
+	if !f.TestHashable(k1) || !f.Test(k1.BloomBytes()) {
+		t.Errorf("%v should be in.", k1)
+	}
+	if k2 := (testUserKey{"a", "bc"}); f.TestHashable(k2) {
+		t.Errorf("%v should not be in.", k2)
+	}
+	if !New(1000, 4).Add(k1.BloomBytes()).b.Equal(f.b) {
+		t.Errorf("AddHashable should set the bits of Add(BloomBytes())")
+	}
+}
+
+func TestPrecomputed(t *testing.T) {
+	filters := []*BloomFilter{New(1000, 4), New(2000, 4), New(1000, 7), NewWithEstimates(100, 0.01)}
+	key := filters[0].Precompute([]byte("Bess"))
+	for _, f := range filters {
+		f.AddPrecomputed(key)
+		if !f.TestPrecomputed(key) || !f.Test([]byte("Bess")) {
+			t.Errorf("Bess should be in a filter with m=%v, k=%v.", f.Cap(), f.K())
+		}
+		if !New(f.Cap(), f.K()).Add([]byte("Bess")).b.Equal(f.b) {
+			t.Errorf("AddPrecomputed should set the bits of Add")
+		}
+		if f.TestPrecomputed(f.Precompute([]byte("Jane"))) {
+			t.Errorf("Jane should not be in.")
+		}
+	}
+}
+
+// record keys through the interface, as code depending on it would
+func recordVisits(f Interface, keys []string) (repeats int) {
+	for _, key := range keys {
+		if f.TestAndAdd([]byte(key)) {
+			repeats++
+		}
+	}
+	return
+}
+
+func TestInterface(t *testing.T) {
+	var f Interface = New(1000, 4)
+	if repeats := recordVisits(f, []string{"Bess", "Jane", "Bess"}); repeats != 1 {
+		t.Errorf("Expected 1 repeat, got %v", repeats)
+	}
+	if !f.Test([]byte("Jane")) || f.Cap() != 1000 || f.K() != 4 {
+		t.Errorf("The interface should reach the filter")
+	}
+	if !f.Add([]byte("Emma")).Test([]byte("Emma")) {
+		t.Errorf("Emma should be in.")
+	}
+}
+
+func TestNilAndEmptyKeys(t *testing.T) {
+	f := New(1000, 4)
+	if f.Test(nil) || f.Test([]byte{}) {
+		t.Errorf("The empty key should not be in an empty filter")
+	}
+	f.Add(nil)
+	if !f.Test(nil) || !f.Test([]byte{}) || !f.TestString("") {
+		t.Errorf("Adding nil should add the empty key")
+	}
+	g := New(1000, 4).Add([]byte{})
+	if !g.Test(nil) || !g.b.Equal(f.b) {
+		t.Errorf("nil and empty keys should set the same bits")
+	}
+	if fmt.Sprint(f.Locations(nil)) != fmt.Sprint(f.Locations([]byte{})) {
+		t.Errorf("nil and empty keys should have the same locations")
+	}
+	if f.Test([]byte{0}) {
+		t.Errorf("A zero byte is not the empty key")
+	}
+}
+
+func TestAddAll(t *testing.T) {
+	f := New(1000, 4)
+	if len(f.AddAll(nil).TestAll(nil)) != 0 {
+		t.Errorf("TestAll of no items should be empty")
+	}
+	if f.b.Count() != 0 {
+		t.Errorf("AddAll of no items should not set any bits")
+	}
+	f.AddAll([][]byte{[]byte("Bess"), []byte("Jane")})
+	items := [][]byte{[]byte("Jane"), []byte("Tom"), []byte("Bess")}
+	expected := []bool{true, false, true}
+	for i, present := range f.TestAll(items) {
+		if present != expected[i] {
+			t.Errorf("%v: expected %v, got %v", items[i], expected[i], present)
+		}
+	}
+}
+
+// a context canceled after its Err has been called checks times, to
+// cancel operations midway deterministically
+type countdownContext struct {
+	context.Context
+	checks int
+}
+
+func (c *countdownContext) Err() error {
+	if c.checks == 0 {
+		return context.Canceled
+	}
+	c.checks--
+	return nil
+}
+
+func TestAddAllContext(t *testing.T) {
+	items := make([][]byte, 3*contextCheckInterval)
+	for i := range items {
+		items[i] = make([]byte, 4)
+		binary.BigEndian.PutUint32(items[i], uint32(i))
+	}
+	f := New(100000, 4)
+	if err := f.AddAllContext(context.Background(), items); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Len() != uint(len(items)) {
+		t.Errorf("Expected %v keys, got %v", len(items), f.Len())
+	}
+	g := New(100000, 4)
+	err := g.AddAllContext(&countdownContext{context.Background(), 1}, items)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	// the items before the cancellation was noticed stay
+	if g.Len() != contextCheckInterval || !g.Test(items[0]) {
+		t.Errorf("Expected the first %v keys to be added, got %v", contextCheckInterval, g.Len())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := New(1000, 4).AddAllContext(ctx, items); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestEstimateFalsePositiveRateContext(t *testing.T) {
+	f := New(40000, 3)
+	rate, err := f.EstimateFalsePositiveRateContext(context.Background(), 10000)
+	if err != nil || rate != f.EstimateFalsePositiveRate(10000) {
+		t.Errorf("Expected the rate of EstimateFalsePositiveRate, got %v, %v", rate, err)
+	}
+	for _, checks := range []int{1, 4} {
+		// cancelled while adding, then while testing
+		_, err := f.EstimateFalsePositiveRateContext(&countdownContext{context.Background(), checks}, 10000)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled after %v checks, got %v", checks, err)
+		}
+	}
+}
+
+func TestTestAllParallel(t *testing.T) {
+	f := NewWithEstimates(1000, 0.01)
+	r := rand.New(rand.NewSource(1))
+	items := make([][]byte, 2000)
+	for i := range items {
+		items[i] = make([]byte, 8)
+		binary.BigEndian.PutUint64(items[i], r.Uint64())
+		if i%2 == 0 {
+			f.Add(items[i])
+		}
+	}
+	serial := f.TestAll(items)
+	for _, workers := range []int{0, 1, 3, 8, 5000} {
+		parallel := f.TestAllParallel(items, workers)
+		for i := range serial {
+			if parallel[i] != serial[i] {
+				t.Errorf("%v workers: item %v should be %v, got %v", workers, i, serial[i], parallel[i])
+			}
+		}
+	}
+	if len(f.TestAllParallel(nil, 4)) != 0 {
+		t.Errorf("TestAllParallel of no items should be empty")
+	}
+}
+
+// the locations documented by NewDeterministic, computed independently
+func TestNewDeterministic(t *testing.T) {
+	f := NewDeterministic(1000, 5)
+	for key, want := range map[string][]uint{
+		"Bess":     {372, 970, 569, 170, 774},
+		"Jane":     {9, 855, 702, 551, 403},
+		"":         {733, 457, 182, 909, 639},
+		"\x00\x01": {492, 843, 195, 549, 906},
+	} {
+		if got := f.Locations([]byte(key)); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%q should map to %v, got %v", key, want, got)
+		}
+	}
+	// the wide hashes, without allocating 2^32 bits
+	f.m = math.MaxUint32 + 1
+	if a, b := f.key_hashes([]byte("Bess")); a != 0xe54bbf4eb5ac6ca7 || b != 0xce9fbb06ca6dee73 {
+		t.Errorf("Unexpected wide hashes %#x, %#x", a, b)
+	}
+}
+
+func TestEnhancedDoubleHashing(t *testing.T) {
+	m, k, n := uint(1<<14), uint(20), 1000
+	f := New(m, k)
+	// locations from the first k terms of a + b*i + (i*i*i - i)/6
+	a, b := f.base_hashes([]byte("Bess"))
+	for i, loc := range f.Locations([]byte("Bess")) {
+		ui := uint(i)
+		if want := (uint(a) + uint(b)*ui + (ui*ui*ui-ui)/6) % m; loc != want {
+			t.Errorf("Location %v should be %v, got %v", i, want, loc)
+		}
+	}
+	// the plain a + b*i scheme, for comparison
+	g := New(m, k)
+	linear := func(data []byte, fn func(loc uint) bool) {
+		a, b := g.base_hashes(data)
+		for i := uint(0); i < k; i++ {
+			if !fn((uint(a) + uint(b)*i) % m) {
+				return
+			}
+		}
+	}
+	r := rand.New(rand.NewSource(1))
+	key := make([]byte, 8)
+	for i := 0; i < n; i++ {
+		r.Read(key)
+		f.Add(key)
+		linear(key, func(loc uint) bool {
+			g.b.Set(loc)
+			return true
+		})
+	}
+	enhanced, plain := 0, 0
+	for i := 0; i < 200000; i++ {
+		r.Read(key)
+		if f.Test(key) {
+			enhanced++
+		}
+		present := true
+		linear(key, func(loc uint) bool {
+			present = g.b.Test(loc)
+			return present
+		})
+		if present {
+			plain++
+		}
+	}
+	if enhanced >= plain {
+		t.Errorf("Enhanced double hashing should give fewer false positives: enhanced %v, plain %v", enhanced, plain)
+	}
+}
+
+func TestWideLocations(t *testing.T) {
+	for _, m := range []uint{1 << 40, 1<<40 + 7, 1<<33 + 1} {
+		// only the locations are needed, so skip allocating the bitset
+		f := New(64, 4)
+		f.m = m
+		f.mask = maskFor(m)
+		buckets := make([]int, 16)
+		high := 0
+		n1 := make([]byte, 4)
+		for i := uint32(0); i < 4000; i++ {
+			binary.BigEndian.PutUint32(n1, i)
+			locs := f.Locations(n1)
+			sa, sb := f.string_hashes(string(n1))
+			wa, wb := f.wide_hashes(n1)
+			if sa != wa || sb != wb {
+				t.Errorf("Strings should be located like byte slices")
+			}
+			for _, loc := range locs {
+				if loc >= m {
+					t.Fatalf("Location %v out of range for m=%v", loc, m)
+				}
+				if loc > math.MaxUint32 {
+					high++
+				}
+				buckets[loc/(m/16+1)]++
+			}
+		}
+		// with 16000 locations, every sixteenth of the range gets about 1000
+		for i, c := range buckets {
+			if c < 800 || c > 1200 {
+				t.Errorf("m=%v: %v locations in bucket %v, expected about 1000", m, c, i)
+			}
+		}
+		expected := 16000 * (1 - float64(math.MaxUint32)/float64(m))
+		if float64(high) < 0.95*expected {
+			t.Errorf("m=%v: only %v of 16000 locations above 2^32, expected about %v", m, high, expected)
+		}
+	}
+}
+
+func TestNewWithEstimatesChecked(t *testing.T) {
+	f, err := NewWithEstimatesChecked(1000, 0.01)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	} else if m, k := EstimateParameters(1000, 0.01); f.Cap() != m || f.K() != k {
+		t.Errorf("Expected m=%v, k=%v, got m=%v, k=%v", m, k, f.Cap(), f.K())
+	}
+	invalid := []struct {
+		n  uint
+		fp float64
+	}{
+		{0, 0.01},
+		{1000, 0},
+		{1000, -0.5},
+		{1000, 1},
+		{1000, 1.5},
+		{1000, math.NaN()},
+	}
+	for _, c := range invalid {
+		if _, err := NewWithEstimatesChecked(c.n, c.fp); err == nil {
+			t.Errorf("Expected an error for n=%v, fp=%v", c.n, c.fp)
+		}
+	}
+}
+
+func TestNewZeroParameters(t *testing.T) {
+	for _, c := range [][2]uint{{0, 4}, {1000, 0}, {0, 0}} {
+		f := New(c[0], c[1])
+		if f.Cap() == 0 || f.K() == 0 {
+			t.Errorf("New(%v, %v) should give at least one bit and hash, got m=%v, k=%v", c[0], c[1], f.Cap(), f.K())
+		}
+		n1 := []byte("Bess")
+		f.Add(n1)
+		if !f.Test(n1) {
+			t.Errorf("%v should be in.", n1)
+		}
+	}
+}
+
+func TestNewFromBitSetZeroParameters(t *testing.T) {
+	if _, err := NewFromBitSet(bitset.New(0), 4); err == nil {
+		t.Errorf("An empty bitset should be rejected")
+	}
+	if _, err := NewFromBitSet(bitset.New(64), 0); err == nil {
+		t.Errorf("k of 0 should be rejected")
+	}
+}
+
+func TestLocationsZeroM(t *testing.T) {
+	if locs := locations(fnv.New64(), []byte("Bess"), 0, 4); len(locs) != 0 {
+		t.Errorf("There should be no locations with m of 0, got %v", locs)
+	}
+}
+
+func TestOptimalMK(t *testing.T) {
+	for _, c := range []struct {
+		n    uint
+		fp   float64
+		m, k uint
+	}{
+		{1000, 0.01, 9586, 7},
+		{1000000, 0.001, 14377588, 10},
+		{10000, 0.05, 62353, 4},
+	} {
+		m := OptimalM(c.n, c.fp)
+		if m != c.m {
+			t.Errorf("OptimalM(%v, %v) = %v, expected %v", c.n, c.fp, m, c.m)
+		}
+		if k := OptimalK(m, c.n); k != c.k {
+			t.Errorf("OptimalK(%v, %v) = %v, expected %v", m, c.n, k, c.k)
+		}
+	}
+	if k := OptimalK(10, 1000); k != 1 {
+		t.Errorf("OptimalK should be at least 1, got %v", k)
+	}
+	if k := OptimalK(1000, 0); k != 693 {
+		t.Errorf("OptimalK with n of 0 should take n as 1, got %v", k)
+	}
+	for _, fp := range []float64{1, 1.5, math.Inf(1), math.NaN()} {
+		if m := OptimalM(10, fp); m != 1 {
+			t.Errorf("OptimalM(10, %v) = %v, expected 1", fp, m)
+		}
+	}
+	for _, fp := range []float64{0, -1, math.Inf(-1)} {
+		if m, want := OptimalM(10, fp), OptimalM(10, 1e-12); m != want {
+			t.Errorf("OptimalM(10, %v) = %v, expected %v as for 1e-12", fp, m, want)
+		}
+	}
+	if m, want := OptimalM(0, 0.01), OptimalM(1, 0.01); m != want {
+		t.Errorf("OptimalM(0, 0.01) = %v, expected %v as for n of 1", m, want)
+	}
+}
+
+func TestEstimateParametersMeetTarget(t *testing.T) {
+	for _, n := range []uint{1, 10, 1000, 100000} {
+		for fp := 0.1; fp >= 1e-5; fp /= 2 {
+			m, k := EstimateParameters(n, fp)
+			if rate := New(m, k).FalsePositiveRate(n); rate > fp {
+				t.Errorf("n=%v, fp=%v: m=%v, k=%v give a rate of %v", n, fp, m, k, rate)
+			}
+			// and m is not much larger than the continuous optimum
+			optimal := -float64(n) * math.Log(fp) / (math.Ln2 * math.Ln2)
+			if float64(m) > 1.05*optimal+2 {
+				t.Errorf("n=%v, fp=%v: m=%v is too far above the optimum %v", n, fp, m, optimal)
+			}
+		}
+	}
+}
+
+func TestEstimateParametersDegenerate(t *testing.T) {
+	// any filter meets these rates
+	for _, fp := range []float64{1, 2, math.Inf(1), math.NaN()} {
+		if m, k := EstimateParameters(1000, fp); m != 1 || k != 1 {
+			t.Errorf("fp=%v: expected m=1, k=1, got m=%v, k=%v", fp, m, k)
+		}
+	}
+	em, ek := EstimateParameters(1000, 1e-12)
+	for _, fp := range []float64{0, -0.5, math.Inf(-1)} {
+		if m, k := EstimateParameters(1000, fp); m != em || k != ek {
+			t.Errorf("fp=%v: expected m=%v, k=%v as for 1e-12, got m=%v, k=%v", fp, em, ek, m, k)
+		}
+	}
+	// subnormal rates, which growing m cannot meet, end as the smallest
+	// normal one
+	em, ek = EstimateParameters(1e9, 0x1p-1022)
+	for _, fp := range []float64{math.SmallestNonzeroFloat64, 1e-320} {
+		if m, k := EstimateParameters(1e9, fp); m != em || k != ek {
+			t.Errorf("fp=%v: expected m=%v, k=%v as for 2^-1022, got m=%v, k=%v", fp, em, ek, m, k)
+		}
+	}
+	em, ek = EstimateParameters(1, 0.01)
+	if m, k := EstimateParameters(0, 0.01); m != em || k != ek {
+		t.Errorf("n=0: expected m=%v, k=%v as for n=1, got m=%v, k=%v", em, ek, m, k)
+	}
+	f := NewWithEstimates(0, math.NaN())
+	f.AddString("Bess")
+	if !f.TestString("Bess") {
+		t.Errorf("Bess should be in.")
+	}
+}
+
+func TestMinimalMForExactish(t *testing.T) {
+	n := uint(1000)
+	m, k := MinimalMForExactish(n)
+	if rate := falsePositiveRate(m, k, n); rate > 1e-12 {
+		t.Errorf("m=%v, k=%v give rate %v, above 1e-12", m, k, rate)
+	}
+	f := New(m, k)
+	r := rand.New(rand.NewSource(1))
+	n1 := make([]byte, 8)
+	for i := uint(0); i < n; i++ {
+		binary.BigEndian.PutUint64(n1, r.Uint64())
+		f.Add(n1)
+	}
+	for i := 0; i < 1000000; i++ {
+		binary.BigEndian.PutUint64(n1, r.Uint64())
+		if f.Test(n1) {
+			t.Fatalf("Unexpected false positive %v", n1)
+		}
+	}
+	if m, k := MinimalMForExactish(0); m == 0 || k == 0 {
+		t.Errorf("No items should be taken as 1, got m=%v, k=%v", m, k)
+	}
+}
+
+func TestAdvise(t *testing.T) {
+	for _, fp := range []float64{0.1, 0.01, 0.001, 0.0001} {
+		n := uint(10000)
+		m, k, size, realized := Advise(n, fp)
+		if em, ek := EstimateParameters(n, fp); m != em || k != ek {
+			t.Errorf("fp=%v: expected m=%v, k=%v as EstimateParameters, got m=%v, k=%v", fp, em, ek, m, k)
+		}
+		if size != New(m, k).ByteSize() {
+			t.Errorf("fp=%v: %v bytes should be ByteSize of m=%v bits", fp, size, m)
+		}
+		analytic := math.Pow(1-math.Exp(-float64(k)*float64(n)/float64(m)), float64(k))
+		if math.Abs(realized-analytic) > 1e-12 || realized > fp {
+			t.Errorf("fp=%v: realized rate %v should be the analytic %v, at most fp", fp, realized, analytic)
+		}
+	}
+}
+
+func TestNewWithMaxBytes(t *testing.T) {
+	for _, c := range [][2]uint{{1 << 10, 1000}, {1 << 16, 10000}, {1000, 100000}, {64, 0}} {
+		maxBytes, n := c[0], c[1]
+		f := NewWithMaxBytes(maxBytes, n)
+		if f.Cap() > maxBytes*8 {
+			t.Errorf("%v bits do not fit in %v bytes", f.Cap(), maxBytes)
+		}
+		if n == 0 {
+			continue
+		}
+		// no other k does better for this m and n
+		rate := f.FalsePositiveRate(n)
+		for _, k := range []uint{f.K() - 1, f.K() + 1} {
+			if k > 0 && New(f.Cap(), k).FalsePositiveRate(n) < rate {
+				t.Errorf("maxBytes=%v, n=%v: k=%v beats k=%v", maxBytes, n, k, f.K())
+			}
+		}
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	for _, m := range []uint{1, 7, 8, 9, 64, 1000, 1 << 20} {
+		if size := New(m, 4).ByteSize(); size != (m+7)/8 {
+			t.Errorf("m=%v: expected %v bytes, got %v", m, (m+7)/8, size)
+		}
+	}
+	if f := NewWithMaxBytes(1000, 100); f.ByteSize() > 1000 {
+		t.Errorf("Filter should fit in 1000 bytes, takes %v", f.ByteSize())
+	}
+}
+
+func TestReset(t *testing.T) {
+	f := New(1000, 4)
+	n1 := []byte("Bess")
+	f.Add(n1)
+	f.Reset()
+	if f.Test(n1) {
+		t.Errorf("%v should not be in after Reset.", n1)
+	}
+	if f.Cap() != 1000 || f.K() != 4 {
+		t.Errorf("Reset should keep m and k, got m=%v, k=%v", f.Cap(), f.K())
+	}
+	f.Add(n1)
+	f.ResetWith(1024, 3)
+	if f.Cap() != 1024 || f.K() != 3 {
+		t.Errorf("Expected m=1024, k=3, got m=%v, k=%v", f.Cap(), f.K())
+	}
+	if f.Test(n1) {
+		t.Errorf("%v should not be in after ResetWith.", n1)
+	}
+	f.Add(n1)
+	if !f.b.Equal(New(1024, 3).Add(n1).b) {
+		t.Errorf("ResetWith should behave like New")
+	}
+}
+
+func TestString(t *testing.T) {
+	f := New(20000, 7)
+	if s := fmt.Sprintf("%v", f); s != "BloomFilter{m: 20000, k: 7}" {
+		t.Errorf("Unexpected description %v", s)
+	}
+}
+
+func TestStats(t *testing.T) {
+	f := New(10000, 4)
+	if s := f.Stats(); s.M != 10000 || s.K != 4 || s.SetBits != 0 || s.EstimatedCount != 0 || s.CurrentFPRate != 0 {
+		t.Errorf("Unexpected stats for an empty filter: %+v", s)
+	}
+	r := rand.New(rand.NewSource(1))
+	key := make([]byte, 8)
+	for i := 0; i < 1000; i++ {
+		r.Read(key)
+		f.Add(key)
+	}
+	s := f.Stats()
+	if s.SetBits != f.b.Count() || s.FillRatio != float64(s.SetBits)/10000 {
+		t.Errorf("Inconsistent fill: %+v", s)
+	}
+	if math.Abs(s.EstimatedCount-1000) > 50 {
+		t.Errorf("Estimated count %v too far from 1000", s.EstimatedCount)
+	}
+	if s.CurrentFPRate != f.CurrentFalsePositiveRate() {
+		t.Errorf("Expected rate %v, got %v", f.CurrentFalsePositiveRate(), s.CurrentFPRate)
+	}
+	if math.Abs(s.CurrentFPRate-f.FalsePositiveRate(1000)) > 0.1*f.FalsePositiveRate(1000) {
+		t.Errorf("Current rate %v too far from %v", s.CurrentFPRate, f.FalsePositiveRate(1000))
+	}
+}
+
+func TestLen(t *testing.T) {
+	f := New(1000, 4)
+	f.Add([]byte("Bess"))
+	f.AddString("Jane")
+	f.Add([]byte("Bess"))
+	if f.Len() != 3 {
+		t.Errorf("Expected 3 adds, got %v", f.Len())
+	}
+	if f.DistinctLen() != 2 {
+		t.Errorf("Expected 2 distinct adds, got %v", f.DistinctLen())
+	}
+	g := f.Copy()
+	if g.Len() != 3 || g.DistinctLen() != 2 {
+		t.Errorf("Copy should keep the counts, got %v and %v", g.Len(), g.DistinctLen())
+	}
+	f.ClearAll()
+	if f.Len() != 0 || f.DistinctLen() != 0 {
+		t.Errorf("ClearAll should reset the counts, got %v and %v", f.Len(), f.DistinctLen())
+	}
+	f.AddAll([][]byte{[]byte("Bess"), []byte("Bess")})
+	if f.Len() != 2 || f.DistinctLen() != 1 {
+		t.Errorf("Expected 2 adds, 1 distinct, got %v and %v", f.Len(), f.DistinctLen())
+	}
+}
+
+func TestNewFromBitSet(t *testing.T) {
+	n1 := []byte("Bess")
+	b := bitset.New(1000)
+	for _, loc := range New(1000, 4).Locations(n1) {
+		b.Set(loc)
+	}
+	f, err := NewFromBitSet(b, 4)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Cap() != 1000 || f.K() != 4 {
+		t.Errorf("Expected m=1000, k=4, got m=%v, k=%v", f.Cap(), f.K())
+	}
+	if !f.Test(n1) {
+		t.Errorf("%v should be in.", n1)
+	}
+	if f.Test([]byte("Jane")) {
+		t.Errorf("Jane should not be in.")
+	}
+	// the bitset is shared, not copied
+	f.AddString("Jane")
+	if b.Count() <= 4 {
+		t.Errorf("Adding to the filter should set bits of the bitset")
+	}
+	if _, err := NewFromBitSet(bitset.New(0), 4); err == nil {
+		t.Errorf("An empty bitset should be rejected")
+	}
+	if _, err := NewFromBitSet(nil, 4); err == nil {
+		t.Errorf("A nil bitset should be rejected")
+	}
+	if _, err := NewFromBitSet(bitset.New(1000), 0); err == nil {
+		t.Errorf("k = 0 should be rejected")
+	}
+}
+
+func TestAddChanged(t *testing.T) {
+	f := New(1000, 4)
+	n1 := []byte("Bess")
+	if !f.AddChanged(n1) {
+		t.Errorf("Adding %v the first time should change the filter", n1)
+	}
+	if f.AddChanged(n1) {
+		t.Errorf("Adding %v again should not change the filter", n1)
+	}
+	if !f.Test(n1) {
+		t.Errorf("%v should be in.", n1)
+	}
+}
+
+func TestAddCountingNewBits(t *testing.T) {
+	f := New(1000, 4)
+	n1 := []byte("Bess")
+	distinct := map[uint]bool{}
+	for _, loc := range f.Locations(n1) {
+		distinct[loc] = true
+	}
+	if set := f.AddCountingNewBits(n1); set != len(distinct) || set != 4 {
+		t.Errorf("A new key should set its 4 locations, got %v", set)
+	}
+	if set := f.AddCountingNewBits(n1); set != 0 {
+		t.Errorf("Adding %v again should set no bits, got %v", n1, set)
+	}
+	// Jane's locations overlap those set only partly, if at all
+	n2 := []byte("Jane")
+	before := f.b.Count()
+	set := f.AddCountingNewBits(n2)
+	if uint(set) != f.b.Count()-before || set > 4 {
+		t.Errorf("Expected %v new bits, got %v", f.b.Count()-before, set)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	f := New(1000, 4).AddString("Bess")
+	items := [][]byte{[]byte("Jane"), []byte("Bess"), []byte("Emma"), []byte("Jane"), []byte("Tom")}
+	unseen := f.Dedup(items)
+	expected := []string{"Jane", "Emma", "Tom"}
+	if len(unseen) != len(expected) {
+		t.Fatalf("Expected %v, got %q", expected, unseen)
+	}
+	for i, s := range expected {
+		if string(unseen[i]) != s {
+			t.Errorf("Item %v should be %v, got %s", i, s, unseen[i])
+		}
+	}
+	for _, s := range []string{"Bess", "Jane", "Emma", "Tom"} {
+		if !f.TestString(s) {
+			t.Errorf("%v should be in.", s)
+		}
+	}
+	if unseen := f.Dedup(items); len(unseen) != 0 {
+		t.Errorf("Every item should be seen the second time, got %q", unseen)
+	}
+	if unseen := f.Dedup(nil); len(unseen) != 0 {
+		t.Errorf("No items should give none, got %q", unseen)
+	}
+}
+
+func TestUnionAll(t *testing.T) {
+	filters := make([]*BloomFilter, 10)
+	pairwise := New(10000, 5)
+	for i := range filters {
+		filters[i] = New(10000, 5)
+		// keys i to i+19, overlapping the next filters'
+		for j := i; j < i+20; j++ {
+			filters[i].AddString(fmt.Sprint(j))
+		}
+		if err := pairwise.MergeChecked(filters[i], 1); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	u, err := UnionAll(filters)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for j := 0; j < 29; j++ {
+		if !u.TestString(fmt.Sprint(j)) {
+			t.Errorf("%v should be in.", j)
+		}
+	}
+	if !u.b.Equal(pairwise.b) || u.Len() != pairwise.Len() {
+		t.Errorf("UnionAll should equal merging pairwise")
+	}
+	if filters[0].b.Equal(u.b) {
+		t.Errorf("UnionAll should not change the first filter")
+	}
+	mixed := append(filters[:3:3], New(10000, 4), New(5000, 5))
+	if _, err := UnionAll(mixed); !errors.Is(err, ErrIncompatibleParameters) {
+		t.Errorf("Expected ErrIncompatibleParameters, got %v", err)
+	} else if !strings.Contains(err.Error(), "filter 3") {
+		t.Errorf("The error should name the first mismatch, got %v", err)
+	}
+	seeded := NewWithSeed(10000, 5, 7)
+	if _, err := UnionAll([]*BloomFilter{filters[0], seeded}); !errors.Is(err, ErrIncompatibleParameters) {
+		t.Errorf("Expected ErrIncompatibleParameters for another seed, got %v", err)
+	}
+	if _, err := UnionAll(nil); err == nil {
+		t.Errorf("No filters should be rejected")
+	}
+}
+
+func TestMergeChecked(t *testing.T) {
+	f := NewWithEstimates(1000, 0.01)
+	g := NewWithEstimates(1000, 0.01)
+	r := rand.New(rand.NewSource(1))
+	n1 := make([]byte, 8)
+	for i := 0; i < 300; i++ {
+		binary.BigEndian.PutUint64(n1, r.Uint64())
+		f.Add(n1)
+		binary.BigEndian.PutUint64(n1, r.Uint64())
+		g.Add(n1)
+	}
+	g.AddString("Jane")
+	// 600 keys are within the budget of a filter for 1000
+	if err := f.MergeChecked(g, 0.01); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !f.TestString("Jane") || f.Len() != 601 {
+		t.Errorf("The merge should hold the keys of both")
+	}
+	h := NewWithEstimates(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		binary.BigEndian.PutUint64(n1, r.Uint64())
+		h.Add(n1)
+	}
+	h.AddString("Emma")
+	// 1601 keys are not
+	if err := f.MergeChecked(h, 0.01); !errors.Is(err, ErrFalsePositiveExceeded) {
+		t.Errorf("Expected ErrFalsePositiveExceeded, got %v", err)
+	}
+	if !f.TestString("Emma") {
+		t.Errorf("The merge should complete even over budget")
+	}
+	if err := f.MergeChecked(New(1000, 4), 1); err != ErrIncompatibleParameters {
+		t.Errorf("Expected ErrIncompatibleParameters, got %v", err)
+	}
+	// the same key is at other locations with another seed
+	seeded := NewWithSeed(f.Cap(), f.K(), 7).AddString("Ann")
+	before := f.Copy()
+	if err := f.MergeChecked(seeded, 1); err != ErrIncompatibleParameters {
+		t.Errorf("Expected ErrIncompatibleParameters for another seed, got %v", err)
+	}
+	if !f.b.Equal(before.b) {
+		t.Errorf("A failed merge should leave the filter unchanged")
+	}
+	f.Freeze()
+	if err := f.MergeChecked(g, 1); err != ErrFrozen {
+		t.Errorf("Expected ErrFrozen, got %v", err)
+	}
+}
+
+func TestIsSubsetOf(t *testing.T) {
+	f := New(1000, 4)
+	g := New(1000, 4)
+	f.AddString("Bess")
+	g.AddString("Bess").AddString("Jane")
+	if ok, err := f.IsSubsetOf(g); !ok || err != nil {
+		t.Errorf("f should be a subset of g, got %v, %v", ok, err)
+	}
+	if ok, err := g.IsSubsetOf(f); ok || err != nil {
+		t.Errorf("g should not be a subset of f, got %v, %v", ok, err)
+	}
+	if ok, _ := f.IsSubsetOf(f); !ok {
+		t.Errorf("f should be a subset of itself")
+	}
+	if _, err := f.IsSubsetOf(New(1000, 5)); err != ErrIncompatibleParameters {
+		t.Errorf("Expected ErrIncompatibleParameters, got %v", err)
+	}
+	if _, err := f.IsSubsetOf(New(1001, 4)); err != ErrIncompatibleParameters {
+		t.Errorf("Expected ErrIncompatibleParameters, got %v", err)
+	}
+}
+
+func TestEstimateJaccard(t *testing.T) {
+	f := New(20000, 4)
+	g := New(20000, 4)
+	r := rand.New(rand.NewSource(1))
+	n1 := make([]byte, 8)
+	for i := 0; i < 1500; i++ {
+		binary.BigEndian.PutUint64(n1, r.Uint64())
+		if i < 1000 {
+			f.Add(n1)
+		}
+		if i >= 500 {
+			g.Add(n1)
+		}
+	}
+	j, err := f.EstimateJaccard(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(j-1.0/3) > 0.05 {
+		t.Errorf("Jaccard estimate %v should be near 1/3", j)
+	}
+	if j, _ := f.EstimateJaccard(f); math.Abs(j-1) > 1e-9 {
+		t.Errorf("Jaccard estimate of f with itself %v should be 1", j)
+	}
+	if _, err := f.EstimateJaccard(New(20000, 3)); err != ErrIncompatibleParameters {
+		t.Errorf("Expected ErrIncompatibleParameters, got %v", err)
+	}
+}
+
+func TestContains(t *testing.T) {
+	f := New(1000, 4)
+	f.Add([]byte("Bess"))
+	for _, s := range []string{"Bess", "Jane", "Emma"} {
+		if f.Contains([]byte(s)) != f.Test([]byte(s)) {
+			t.Errorf("Contains(%v) should agree with Test", s)
+		}
+	}
+}
+
+func TestAddVariadic(t *testing.T) {
+	f := New(1000, 4)
+	f.AddVariadic([]byte("Bess"), []byte("Jane"), []byte("Emma"))
+	for _, s := range []string{"Bess", "Jane", "Emma"} {
+		if !f.Test([]byte(s)) {
+			t.Errorf("%v should be in.", s)
+		}
+	}
+	if f.Len() != 3 {
+		t.Errorf("Len should be 3, got %v", f.Len())
+	}
+	if f.AddVariadic() != f {
+		t.Errorf("AddVariadic should return the filter")
+	}
+}
+
+func TestAddReader(t *testing.T) {
+	data := bytes.Repeat([]byte("Bess and Jane "), 1000)
+	f := New(1000, 4)
+	g := New(1000, 4)
+	if _, err := f.AddReader(bytes.NewReader(data)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	g.Add(data)
+	if !f.b.Equal(g.b) {
+		t.Errorf("AddReader and Add should set the same bits")
+	}
+	if ok, err := g.TestReader(bytes.NewReader(data)); !ok || err != nil {
+		t.Errorf("TestReader should find data added with Add, got %v, %v", ok, err)
+	}
+	if ok, _ := g.TestReader(bytes.NewReader(data[1:])); ok != g.Test(data[1:]) {
+		t.Errorf("TestReader should agree with Test")
+	}
+	// too large to allocate, so only compare the hashes
+	f.m = math.MaxUint32 + 1
+	ra, rb, err := f.reader_hashes(bytes.NewReader(data))
+	ka, kb := f.key_hashes(data)
+	if err != nil || ra != ka || rb != kb {
+		t.Errorf("Wide filters should hash readers like byte slices")
+	}
+}
+
+func TestAddReaderError(t *testing.T) {
+	f := New(1000, 4)
+	r := io.MultiReader(bytes.NewReader([]byte("Bess")), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if _, err := f.AddReader(r); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected the read error, got %v", err)
+	}
+	if f.Len() != 0 || f.b.Count() != 0 {
+		t.Errorf("A failed AddReader should leave the filter unchanged")
+	}
+	if _, err := f.TestReader(iotest.ErrReader(io.ErrUnexpectedEOF)); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected the read error, got %v", err)
 	}
 }
 
-func TestDirect15_10(t *testing.T) {
-	n := uint(10000)
-	k := uint(10)
-	load := uint(15)
-	f := New(n*load, k)
-	fp_rate := f.EstimateFalsePositiveRate(n)
-	if fp_rate > 0.0001 {
-		t.Errorf("False positive rate too high: load=%v, k=%v, %f", load, k, fp_rate)
+func TestApproximateCount(t *testing.T) {
+	f := New(20000, 4)
+	if c := f.ApproximateCount(); c != 0 {
+		t.Errorf("An empty filter should count 0, got %v", c)
+	}
+	r := rand.New(rand.NewSource(1))
+	n1 := make([]byte, 8)
+	for i := 0; i < 1000; i++ {
+		binary.BigEndian.PutUint64(n1, r.Uint64())
+		f.Add(n1)
+	}
+	if c := f.ApproximateCount(); c < 950 || c > 1050 {
+		t.Errorf("Count %v should be near 1000", c)
+	}
+	g := New(64, 1)
+	for i := uint(0); i < 64; i++ {
+		g.b.Set(i)
+	}
+	if c := g.ApproximateCount(); c != math.MaxUint {
+		t.Errorf("A saturated filter should count math.MaxUint, got %v", c)
 	}
 }
 
-func TestEstimated10_0001(t *testing.T) {
-	n := uint(10000)
-	fp := 0.0001
-	m, k := EstimateParameters(n, fp)
-	f := NewWithEstimates(n, fp)
-	fp_rate := f.EstimateFalsePositiveRate(n)
-	if fp_rate > fp {
-		t.Errorf("False positive rate too high: n: %v, fp: %f, n: %v, k: %v result: %f", n, fp, m, k, fp_rate)
+func TestEfficiency(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	n1 := make([]byte, 8)
+	fill := func(n int) *BloomFilter {
+		f := NewWithEstimates(10000, 0.01)
+		for i := 0; i < n; i++ {
+			binary.BigEndian.PutUint64(n1, r.Uint64())
+			f.Add(n1)
+		}
+		return f
+	}
+	// filled to capacity, the filter is near the optimum
+	actual, optimal := fill(10000).Efficiency()
+	if actual < 9 || actual > 11 || actual/optimal > 1.1 {
+		t.Errorf("At capacity expected about 9.6 bits per item near the optimum, got %v and %v", actual, optimal)
+	}
+	// underfilled, many bits are spent per item for little gain
+	actual, optimal = fill(1000).Efficiency()
+	if actual < 80 || actual/optimal < 2 {
+		t.Errorf("Underfilled, expected about 96 bits per item far above the optimum, got %v and %v", actual, optimal)
+	}
+	// overfilled, the rate is far worse than the bits could give
+	actual, optimal = fill(50000).Efficiency()
+	if actual > 2.5 || actual/optimal < 2 {
+		t.Errorf("Overfilled, expected about 2 bits per item far above the optimum, got %v and %v", actual, optimal)
+	}
+	if actual, optimal := New(1000, 4).Efficiency(); !math.IsInf(actual, 1) || !math.IsInf(optimal, 1) {
+		t.Errorf("An empty filter should give +Inf, got %v and %v", actual, optimal)
 	}
 }
 
-func TestEstimated10_001(t *testing.T) {
-	n := uint(10000)
-	fp := 0.001
-	m, k := EstimateParameters(n, fp)
-	f := NewWithEstimates(n, fp)
-	fp_rate := f.EstimateFalsePositiveRate(n)
-	if fp_rate > fp {
-		t.Errorf("False positive rate too high: n: %v, fp: %f, n: %v, k: %v result: %f", n, fp, m, k, fp_rate)
+func TestWouldResizeTo(t *testing.T) {
+	f := New(20000, 4)
+	r := rand.New(rand.NewSource(1))
+	keys := make([][]byte, 1000)
+	for i := range keys {
+		keys[i] = make([]byte, 8)
+		binary.BigEndian.PutUint64(keys[i], r.Uint64())
+		f.Add(keys[i])
+	}
+	m, k := f.WouldResizeTo(0.001)
+	if rate := falsePositiveRate(m, k, f.ApproximateCount()); rate > 0.001 {
+		t.Errorf("m=%v, k=%v give rate %v for the estimated count, above 0.001", m, k, rate)
+	}
+	g := New(m, k).AddAll(keys)
+	fp := 0
+	n1 := make([]byte, 8)
+	for i := 0; i < 100000; i++ {
+		binary.BigEndian.PutUint64(n1, r.Uint64())
+		if g.Test(n1) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 100000; rate > 0.0015 {
+		t.Errorf("The rebuilt filter has rate %v, above 0.001", rate)
+	}
+	if m, k := New(1000, 4).WouldResizeTo(0.01); m == 0 || k == 0 {
+		t.Errorf("An empty filter should be resized as for 1 key, got m=%v, k=%v", m, k)
+	}
+}
+
+func TestEstimateUnionCount(t *testing.T) {
+	a := New(20000, 4)
+	b := New(20000, 4)
+	r := rand.New(rand.NewSource(1))
+	n1 := make([]byte, 8)
+	// 1000 keys each, 300 of them in both
+	for i := 0; i < 1700; i++ {
+		binary.BigEndian.PutUint64(n1, r.Uint64())
+		if i < 1000 {
+			a.Add(n1)
+		}
+		if i >= 700 {
+			b.Add(n1)
+		}
+	}
+	union, err := EstimateUnionCount(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if union < 1620 || union > 1780 {
+		t.Errorf("Union count %v should be near 1700", union)
+	}
+	sum := float64(a.ApproximateCount() + b.ApproximateCount() - 300)
+	if math.Abs(float64(union)-sum) > 0.05*sum {
+		t.Errorf("Union count %v should be near count(A) + count(B) - count(A ∩ B) = %v", union, sum)
+	}
+	if _, err := EstimateUnionCount(a, New(20000, 5)); err != ErrIncompatibleParameters {
+		t.Errorf("Expected ErrIncompatibleParameters, got %v", err)
+	}
+}
+
+func TestTestAndAdd(t *testing.T) {
+	f := New(1000, 4)
+	n1 := []byte("Bess")
+	if f.TestAndAdd(n1) {
+		t.Errorf("%v should not have been in.", n1)
+	}
+	if !f.Test(n1) {
+		t.Errorf("%v should be in.", n1)
+	}
+	if !f.TestAndAdd(n1) {
+		t.Errorf("%v should have been in.", n1)
+	}
+}
+
+func TestAddUint64(t *testing.T) {
+	f := New(1000, 4)
+	g := New(1000, 4)
+	f.AddUint64(100)
+	n1 := make([]byte, 8)
+	binary.BigEndian.PutUint64(n1, 100)
+	g.Add(n1)
+	if !f.b.Equal(g.b) {
+		t.Errorf("AddUint64(100) should set the bits of its big-endian encoding")
+	}
+	if !g.TestUint64(100) || g.TestUint64(101) {
+		t.Errorf("TestUint64 should agree with Test")
+	}
+	f.AddInt(-5)
+	if !f.TestInt(-5) || !f.TestUint64(uint64(1<<64-5)) {
+		t.Errorf("AddInt(-5) should add its two's complement")
+	}
+	if allocs := testing.AllocsPerRun(100, func() { f.AddUint64(7) }); allocs != 0 {
+		t.Errorf("AddUint64 should not allocate, got %v allocations", allocs)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { f.TestUint64(7) }); allocs != 0 {
+		t.Errorf("TestUint64 should not allocate, got %v allocations", allocs)
+	}
+}
+
+func TestWithByteOrder(t *testing.T) {
+	n1 := make([]byte, 8)
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		f := New(1000, 4).WithByteOrder(order)
+		g := New(1000, 4)
+		f.AddUint64(100).AddInt(-5)
+		if !f.TestUint64(100) || !f.TestInt(-5) || f.TestUint64(101) {
+			t.Errorf("%v: integer keys should test as added", order)
+		}
+		order.PutUint64(n1, 100)
+		g.Add(n1)
+		order.PutUint64(n1, uint64(1<<64-5))
+		g.Add(n1)
+		if !f.b.Equal(g.b) {
+			t.Errorf("%v: AddUint64 should set the bits of the key in that order", order)
+		}
+		if allocs := testing.AllocsPerRun(100, func() { f.AddUint64(7) }); allocs != 0 {
+			t.Errorf("%v: AddUint64 should not allocate, got %v allocations", order, allocs)
+		}
+	}
+	big := New(1000, 4).AddUint64(100)
+	little := New(1000, 4).WithByteOrder(binary.LittleEndian).AddUint64(100)
+	if big.b.Equal(little.b) {
+		t.Errorf("The byte orders should encode 100 differently")
+	}
+}
+
+func TestUnionRehash(t *testing.T) {
+	f := New(1000, 4)
+	f.AddString("Bess")
+	for _, m := range []uint{1000, 3000, 8000} {
+		g := New(m, 4)
+		g.AddString("Jane")
+		u := f.Copy()
+		if err := u.UnionRehash(g); err != nil {
+			t.Fatalf("Unexpected error with m=%v: %v", m, err)
+		}
+		if !u.TestString("Bess") || !u.TestString("Jane") {
+			t.Errorf("The union with m=%v should hold both keys", m)
+		}
+		// the same as adding Jane directly
+		direct := f.Copy().AddString("Jane")
+		if !u.b.Equal(direct.b) {
+			t.Errorf("The union with m=%v should set the same bits as Add", m)
+		}
+		if u.Len() != 2 {
+			t.Errorf("The union should count 2 keys, got %v", u.Len())
+		}
+	}
+	for _, g := range []*BloomFilter{
+		New(1500, 4),            // not a multiple
+		New(500, 4),             // smaller
+		New(1000, 5),            // different k
+		NewWithSeed(1000, 4, 7), // different seed
+	} {
+		u := f.Copy()
+		if err := u.UnionRehash(g); err != ErrIncompatibleParameters {
+			t.Errorf("Expected ErrIncompatibleParameters for %v, got %v", g, err)
+		}
+		if !u.b.Equal(f.b) {
+			t.Errorf("A failed union should leave the filter unchanged")
+		}
+	}
+}
+
+func TestSetBits(t *testing.T) {
+	f := New(1000, 4)
+	want := make(map[uint]bool)
+	for _, s := range []string{"Bess", "Jane", "Emma"} {
+		f.Add([]byte(s))
+		for _, loc := range f.Locations([]byte(s)) {
+			want[loc] = true
+		}
+	}
+	var got []uint
+	f.SetBits(func(pos uint) bool {
+		got = append(got, pos)
+		return true
+	})
+	if len(got) != len(want) {
+		t.Errorf("Expected %v set bits, got %v", len(want), len(got))
+	}
+	for i, pos := range got {
+		if !want[pos] {
+			t.Errorf("Bit %v is not a location of any key", pos)
+		}
+		if i > 0 && pos <= got[i-1] {
+			t.Errorf("Positions should be ascending, got %v", got)
+		}
+	}
+	n := 0
+	f.SetBits(func(pos uint) bool {
+		n++
+		return n < 2
+	})
+	if n != 2 {
+		t.Errorf("SetBits should stop when fn returns false, got %v calls", n)
+	}
+}
+
+func TestAddFromChannel(t *testing.T) {
+	f := New(10000, 4)
+	ch := make(chan []byte)
+	var wg sync.WaitGroup
+	for p := 0; p < 4; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				n1 := make([]byte, 4)
+				binary.BigEndian.PutUint32(n1, uint32(p*100+i))
+				ch <- n1
+			}
+		}(p)
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	if f.AddFromChannel(ch) != f {
+		t.Errorf("AddFromChannel should return the filter")
+	}
+	if f.Len() != 400 {
+		t.Errorf("Expected 400 keys, got %v", f.Len())
+	}
+	n1 := make([]byte, 4)
+	for i := uint32(0); i < 400; i++ {
+		binary.BigEndian.PutUint32(n1, i)
+		if !f.Test(n1) {
+			t.Errorf("%v should be in.", n1)
+		}
+	}
+}
+
+func TestTestOrAdd(t *testing.T) {
+	f := New(1000, 4)
+	g := New(1000, 4)
+	n1 := []byte("Bess")
+	if f.TestOrAdd(n1) {
+		t.Errorf("%v should not have been in.", n1)
+	}
+	if !f.Test(n1) {
+		t.Errorf("%v should be in.", n1)
+	}
+	if !f.TestOrAdd(n1) {
+		t.Errorf("%v should have been in.", n1)
+	}
+	g.TestAndAdd(n1)
+	g.TestAndAdd(n1)
+	if !f.b.Equal(g.b) {
+		t.Errorf("TestOrAdd and TestAndAdd should set the same bits")
+	}
+	if f.Len() != 1 || g.Len() != 2 {
+		t.Errorf("TestOrAdd should count the key once and TestAndAdd twice, got %v and %v", f.Len(), g.Len())
+	}
+}
+
+// call fn, returning what it panicked with
+func panicValue(fn func()) (v interface{}) {
+	defer func() { v = recover() }()
+	fn()
+	return
+}
+
+func TestLocationHistogram(t *testing.T) {
+	m, k, samples := uint(1000), uint(4), 100000
+	f := New(m, k)
+	hits := f.LocationHistogram(samples, func(i int) []byte {
+		return []byte(fmt.Sprintf("key-%d", i))
+	})
+	if uint(len(hits)) != m {
+		t.Fatalf("Expected %v counts, got %v", m, len(hits))
+	}
+	expected := float64(samples) * float64(k) / float64(m)
+	chi2, total := 0.0, uint(0)
+	for _, h := range hits {
+		d := float64(h) - expected
+		chi2 += d * d / expected
+		total += h
+	}
+	if total != uint(samples)*k {
+		t.Errorf("Expected %v locations, got %v", uint(samples)*k, total)
+	}
+	// m-1 degrees of freedom; 1200 is far in the upper tail
+	if chi2 > 1200 {
+		t.Errorf("Chi-square %v is too high for an even spread", chi2)
+	}
+	if f.b.Count() != 0 {
+		t.Errorf("LocationHistogram should not change the filter")
+	}
+}
+
+func TestSharedLocations(t *testing.T) {
+	n1 := []byte("Bess")
+	if shared := New(1000, 4).SharedLocations(n1, n1); shared != 4 {
+		t.Errorf("A key should share all its 4 locations with itself, got %v", shared)
+	}
+	// with Bess alone in a small filter, a false positive shares all
+	// of its locations with Bess
+	f := New(64, 4).Add(n1)
+	r := rand.New(rand.NewSource(1))
+	key := make([]byte, 8)
+	for found := false; !found; {
+		r.Read(key)
+		found = f.Test(key)
+	}
+	distinct := map[uint]bool{}
+	for _, loc := range f.Locations(key) {
+		distinct[loc] = true
+	}
+	if shared := f.SharedLocations(key, n1); shared != uint(len(distinct)) {
+		t.Errorf("False positive %v should share its %v locations with Bess, got %v", key, len(distinct), shared)
+	}
+	in := map[uint]bool{}
+	for _, loc := range f.Locations(n1) {
+		in[loc] = true
+	}
+	for i := 0; i < 100; i++ {
+		r.Read(key)
+		counted := map[uint]bool{}
+		want := uint(0)
+		for _, loc := range f.Locations(key) {
+			if in[loc] && !counted[loc] {
+				want++
+			}
+			counted[loc] = true
+		}
+		if shared := f.SharedLocations(key, n1); shared != want {
+			t.Errorf("%v should share %v locations with Bess, got %v", key, want, shared)
+		}
+	}
+}
+
+func TestClearRange(t *testing.T) {
+	f := New(1000, 4)
+	for i := uint(0); i < 1000; i++ {
+		f.b.Set(i)
+	}
+	if err := f.ClearRange(200, 300); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := uint(0); i < 1000; i++ {
+		if f.b.Test(i) != (i < 200 || i >= 300) {
+			t.Fatalf("Bit %v should be set only outside [200, 300)", i)
+		}
+	}
+	if err := f.ClearRange(1000, 1000); err != nil || f.b.Count() != 900 {
+		t.Errorf("An empty range should clear nothing")
+	}
+	for _, r := range [][2]uint{{300, 200}, {900, 1001}, {1001, 1002}} {
+		if err := f.ClearRange(r[0], r[1]); err == nil {
+			t.Errorf("Range [%v, %v) should be rejected", r[0], r[1])
+		}
+	}
+	if f.b.Count() != 900 {
+		t.Errorf("Rejected ranges should clear nothing")
+	}
+	f.Freeze()
+	if err := f.ClearRange(0, 10); err != ErrFrozen {
+		t.Errorf("Expected ErrFrozen, got %v", err)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	f := New(1000, 4)
+	n1 := []byte("Bess")
+	f.Add(n1)
+	if f.Frozen() {
+		t.Errorf("A new filter should not be frozen")
+	}
+	f.Freeze()
+	if !f.Frozen() {
+		t.Errorf("The filter should be frozen")
+	}
+	for name, fn := range map[string]func(){
+		"Add":        func() { f.Add([]byte("Jane")) },
+		"AddString":  func() { f.AddString("Jane") },
+		"TestAndAdd": func() { f.TestAndAdd([]byte("Jane")) },
+		"ClearAll":   func() { f.ClearAll() },
+		"ResetWith":  func() { f.ResetWith(10, 1) },
+	} {
+		if v := panicValue(fn); v != ErrFrozen {
+			t.Errorf("%v on a frozen filter should panic with ErrFrozen, got %v", name, v)
+		}
+	}
+	if err := f.UnionRehash(New(1000, 4)); err != ErrFrozen {
+		t.Errorf("Expected ErrFrozen, got %v", err)
+	}
+	if !f.Test(n1) || f.Test([]byte("Jane")) {
+		t.Errorf("A frozen filter should be unchanged")
+	}
+	c := f.Copy()
+	if c.Frozen() {
+		t.Errorf("A copy should not be frozen")
+	}
+	c.Add([]byte("Jane"))
+}
+
+func TestFrozenConcurrentTest(t *testing.T) {
+	f := New(10000, 4)
+	for i := 0; i < 1000; i++ {
+		f.AddInt(i)
+	}
+	f.Freeze()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				if !f.TestInt(i) {
+					t.Errorf("%v should be in.", i)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestEstimateFalsePositiveRateSeeded(t *testing.T) {
+	f := New(10000, 5)
+	f.AddString("Bess")
+	a := f.EstimateFalsePositiveRateSeeded(1000, 10000, 1)
+	b := f.EstimateFalsePositiveRateSeeded(1000, 10000, 1)
+	c := f.EstimateFalsePositiveRateSeeded(1000, 10000, 2)
+	if a != b {
+		t.Errorf("The same seed should give the same estimate, got %v and %v", a, b)
+	}
+	if a == c {
+		t.Errorf("Different seeds should give different estimates, got %v for both", a)
+	}
+	if expected := f.FalsePositiveRate(1000); math.Abs(a-expected) > 0.5*expected {
+		t.Errorf("Estimate %v should be near %v", a, expected)
+	}
+	if f.Len() != 1 || !f.TestString("Bess") {
+		t.Errorf("The filter should be left untouched")
+	}
+	// the keys already stored count
+	g := f.Copy()
+	for i := 0; i < 1000; i++ {
+		g.AddInt(i)
+	}
+	if g.EstimateFalsePositiveRateSeeded(1000, 10000, 1) <= a {
+		t.Errorf("A fuller filter should have a higher estimate")
+	}
+}
+
+func TestSamplesForFP(t *testing.T) {
+	prev := 0
+	for _, fp := range []float64{0.1, 0.01, 0.001, 0.0001} {
+		samples := SamplesForFP(fp, 0.95)
+		if samples <= prev {
+			t.Errorf("Lower rates should need more samples, got %v for %v", samples, fp)
+		}
+		// about 384/fp, within the (1 - fp) factor
+		if want := 1.96 * 1.96 * 100 / fp; float64(samples) < 0.85*want || float64(samples) > 1.01*want {
+			t.Errorf("Expected about %v samples for %v, got %v", want, fp, samples)
+		}
+		prev = samples
+	}
+	if SamplesForFP(0.01, 0.99) <= SamplesForFP(0.01, 0.95) {
+		t.Errorf("Higher confidence should need more samples")
+	}
+	if SamplesForFP(0, 0.95) != math.MaxInt || SamplesForFP(1e-300, 0.95) != math.MaxInt || SamplesForFP(1, 0.95) != 1 {
+		t.Errorf("Degenerate rates should give math.MaxInt or 1")
+	}
+}
+
+func TestEstimateFalsePositiveRateSeededDefaultSamples(t *testing.T) {
+	f := NewWithEstimates(1000, 0.001)
+	rate := f.EstimateFalsePositiveRateSeeded(1000, 0, 1)
+	// 10000 samples would see only about 10 false positives
+	if rate < 0.0007 || rate > 0.0015 {
+		t.Errorf("Estimate %v should be near 0.001", rate)
+	}
+	if f.EstimateFalsePositiveRateSeeded(0, 0, 1) != 0 {
+		t.Errorf("An empty filter has no false positives")
+	}
+}
+
+func TestNewAligned(t *testing.T) {
+	for m, want := range map[uint]uint{0: 64, 1: 64, 63: 64, 64: 64, 65: 128, 1000: 1024} {
+		f := NewAligned(m, 4)
+		if f.Cap() != want {
+			t.Errorf("NewAligned(%v) should round m up to %v, got %v", m, want, f.Cap())
+		}
+		if f.b.Len() != f.Cap() {
+			t.Errorf("The bitset should have %v bits, got %v", f.Cap(), f.b.Len())
+		}
+		n1 := []byte("Bess")
+		f.Add(n1)
+		if !f.Test(n1) {
+			t.Errorf("%v should be in.", n1)
+		}
+		for _, loc := range f.Locations(n1) {
+			if loc >= f.Cap() {
+				t.Errorf("Location %v out of range", loc)
+			}
+		}
+		if g := New(f.Cap(), 4).Add(n1); !g.b.Equal(f.b) {
+			t.Errorf("NewAligned(%v) should locate keys as New(%v)", m, f.Cap())
+		}
+	}
+}
+
+func TestResetHasher(t *testing.T) {
+	f := New(1000, 4)
+	n1 := []byte("Bess")
+	want := f.Locations(n1)
+	// leave garbage in a pooled hasher, as a panic mid-Write would
+	h := f.hashers.Get().(hash.Hash64)
+	h.Write([]byte("garbage"))
+	f.hashers.Put(h)
+	if got := f.Locations(n1); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("A dirty pooled hasher should not change locations, got %v, expected %v", got, want)
+	}
+	f.ResetHasher()
+	if got := f.Locations(n1); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("ResetHasher should not change locations, got %v, expected %v", got, want)
+	}
+	f.Add(n1)
+	if !f.Test(n1) {
+		t.Errorf("%v should be in.", n1)
 	}
+	g := NewWithSeed(1000, 4, 7)
+	want = g.Locations(n1)
+	g.ResetHasher()
+	if got := g.Locations(n1); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("ResetHasher should keep the seed, got %v, expected %v", got, want)
+	}
+}
+
+func TestTestVerbose(t *testing.T) {
+	f := New(1000, 4)
+	n1 := []byte("Bess")
+	f.Add(n1)
+	if present, index := f.TestVerbose(n1); !present || index != -1 {
+		t.Errorf("%v should be in with index -1, got %v, %v", n1, present, index)
+	}
+	n2 := []byte("Jane")
+	locs := f.Locations(n2)
+	// set all but the third location of n2
+	for i, loc := range locs {
+		if i != 2 {
+			f.b.Set(loc)
+		}
+	}
+	want := -1
+	for i, loc := range locs {
+		if !f.b.Test(loc) {
+			want = i
+			break
+		}
+	}
+	if present, index := f.TestVerbose(n2); present || index != want {
+		t.Errorf("%v should be out with index %v, got %v, %v", n2, want, present, index)
+	}
+}
+
+// run with -race to check that TestVerbose takes the lock
+func TestTestVerboseConcurrent(t *testing.T) {
+	f, err := NewBuilder().WithEstimates(10000, 0.01).Concurrent().Build()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := []byte(fmt.Sprint(g*1000 + i))
+				f.Add(key)
+				if present, _ := f.TestVerbose(key); !present {
+					t.Errorf("%s should be in right after Add.", key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
 }
 
 type rw struct {
@@ -120,7 +2043,10 @@ func TestDumpRestore(t *testing.T) {
 	}
 	wr := &rw{make([]byte, 0, 10), 0}
 	Encode(wr, a)
-	b := Decode(wr)
+	b, err := Decode(wr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
 	for _, v := range addValues {
 		if !b.Test(v) { //no false negatives!
 			t.Error("Did not restore properly")
@@ -134,6 +2060,20 @@ func BenchmarkAdd(b *testing.B) {
 	n := 10000000
 	f := NewWithEstimates(uint(n), 0.001)
 	n1 := make([]byte, 4)
+	b.ReportAllocs()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		binary.BigEndian.PutUint32(n1, uint32(i%n))
+		f.Add(n1)
+	}
+}
+
+func BenchmarkAddPowerOfTwo(b *testing.B) {
+	b.StopTimer()
+	n := 10000000
+	m, k := EstimateParameters(uint(n), 0.001)
+	f := NewPowerOfTwo(m, k)
+	n1 := make([]byte, 4)
 	b.StartTimer()
 	for i := 0; i < b.N; i++ {
 		binary.BigEndian.PutUint32(n1, uint32(i%n))
@@ -154,6 +2094,141 @@ func BenchmarkNegativeTest(b *testing.B) {
 	}
 }
 
+func BenchmarkNegativeTestPowerOfTwo(b *testing.B) {
+	b.StopTimer()
+	n := 10000000
+	m, k := EstimateParameters(uint(n), 0.001)
+	f := NewPowerOfTwo(m, k)
+	n1 := make([]byte, 4)
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		binary.BigEndian.PutUint32(n1, uint32(i%n))
+		f.Test(n1)
+	}
+}
+
+// a large filter filled to its design capacity with random keys, and
+// keys added to it and not, for comparing ways of testing
+func benchmarkTestFilter(b *testing.B) (*BloomFilter, [][]byte, [][]byte) {
+	n := 2000000
+	f := NewWithEstimates(uint(n), 0.001)
+	r := rand.New(rand.NewSource(1))
+	in, out := make([][]byte, 1<<16), make([][]byte, 1<<16)
+	for i := 0; i < n; i++ {
+		n1 := make([]byte, 8)
+		binary.BigEndian.PutUint64(n1, r.Uint64())
+		f.Add(n1)
+		if i < len(in) {
+			in[i] = n1
+		}
+	}
+	for i := range out {
+		out[i] = make([]byte, 8)
+		binary.BigEndian.PutUint64(out[i], r.Uint64())
+	}
+	return f, in, out
+}
+
+// test the locations of the base hashes a and b as testHashes does, but
+// computing all of them before reading any, so the reads do not wait on
+// each other. Without word access to the bitset there is nothing to
+// prefetch, and this has measured slower than testHashes, which stops
+// at the first location not set.
+func gatherHashes(f *BloomFilter, a uint64, b uint64) bool {
+	locs := make([]uint, 0, 32)
+	f.forEachHashLocation(a, b, func(loc uint) bool {
+		locs = append(locs, loc)
+		return true
+	})
+	present := true
+	for _, loc := range locs {
+		if !f.b.Test(loc) {
+			present = false
+		}
+	}
+	return present
+}
+
+func BenchmarkTestGather(b *testing.B) {
+	f, in, out := benchmarkTestFilter(b)
+	for _, bm := range []struct {
+		name string
+		keys [][]byte
+	}{{"Positive", in}, {"Negative", out}} {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				f.testHashes(f.key_hashes(bm.keys[i%len(bm.keys)]))
+			}
+		})
+		b.Run(bm.name+"Gathered", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				a, h := f.key_hashes(bm.keys[i%len(bm.keys)])
+				gatherHashes(f, a, h)
+			}
+		})
+	}
+}
+
+// set the locations derived from the hash values a and b in ascending
+// order, sorting them into locs by insertion, for comparison with Add.
+// The bitset sets one bit at a time, so this only gains locality.
+func setSortedHashes(f *BloomFilter, a uint64, b uint64, locs []uint) []uint {
+	locs = locs[:0]
+	f.forEachHashLocation(a, b, func(loc uint) bool {
+		i := len(locs)
+		locs = append(locs, loc)
+		for ; i > 0 && locs[i-1] > loc; i-- {
+			locs[i] = locs[i-1]
+		}
+		locs[i] = loc
+		return true
+	})
+	for _, loc := range locs {
+		f.b.Set(loc)
+	}
+	return locs
+}
+
+func TestSetSortedHashes(t *testing.T) {
+	f, g := New(100000, 24), New(100000, 24)
+	r := rand.New(rand.NewSource(1))
+	key := make([]byte, 8)
+	var locs []uint
+	for i := 0; i < 1000; i++ {
+		r.Read(key)
+		f.Add(key)
+		a, h := f.key_hashes(key)
+		locs = setSortedHashes(g, a, h, locs)
+	}
+	if !f.b.Equal(g.b) {
+		t.Errorf("Setting sorted locations should set the bits of Add")
+	}
+}
+
+func BenchmarkAddSorted(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	keys := make([][]byte, 1<<16)
+	for i := range keys {
+		keys[i] = make([]byte, 8)
+		r.Read(keys[i])
+	}
+	for _, m := range []uint{1 << 20, 1 << 27} {
+		f := New(m, 24)
+		b.Run(fmt.Sprintf("m=%v", m), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				f.Add(keys[i%len(keys)])
+			}
+		})
+		locs := make([]uint, 0, 24)
+		b.Run(fmt.Sprintf("m=%vSorted", m), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				a, h := f.key_hashes(keys[i%len(keys)])
+				locs = setSortedHashes(f, a, h, locs)
+			}
+		})
+	}
+}
+
 func BenchmarkPositiveTest(b *testing.B) {
 	b.StopTimer()
 	//k, m := EstimateParameters(10000,0.01)
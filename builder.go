@@ -0,0 +1,120 @@
+package bloom
+
+/*
+A Builder makes a Bloom filter from any combination of the options of the
+other constructors, checking them when the filter is built. The size is
+given either directly, with WithCapacity and WithHashes, or by WithEstimates
+from the number of items and false positive rate; the other options are
+optional:
+
+	f, err := NewBuilder().WithEstimates(1000000, 0.001).WithSeed(42).Concurrent().Build()
+*/
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"sync"
+)
+
+type Builder struct {
+	m, k       uint
+	n          uint
+	fp         float64
+	estimates  bool
+	hasher     func() hash.Hash64
+	seed       uint
+	order      binary.ByteOrder
+	concurrent bool
+}
+
+// Create a new Builder with no options set
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// Give the filter _m_ bits; needs WithHashes, and conflicts with
+// WithEstimates. Returns the builder (allows chaining)
+func (b *Builder) WithCapacity(m uint) *Builder {
+	b.m = m
+	return b
+}
+
+// Give the filter _k_ hashing functions; conflicts with WithEstimates.
+// Returns the builder (allows chaining)
+func (b *Builder) WithHashes(k uint) *Builder {
+	b.k = k
+	return b
+}
+
+// Size the filter for about n items with fp false positive rate, as
+// NewWithEstimates does. Returns the builder (allows chaining)
+func (b *Builder) WithEstimates(n uint, fp float64) *Builder {
+	b.n, b.fp, b.estimates = n, fp, true
+	return b
+}
+
+// Hash keys with the hashes made by h, as NewWithHasher does; FNV-1 by
+// default. Returns the builder (allows chaining)
+func (b *Builder) WithHasher(h func() hash.Hash64) *Builder {
+	b.hasher = h
+	return b
+}
+
+// Mix seed into the hash of every key, as NewWithSeed does. Returns the
+// builder (allows chaining)
+func (b *Builder) WithSeed(seed uint) *Builder {
+	b.seed = seed
+	return b
+}
+
+// Encode integer keys in order, as WithByteOrder of the filter. Returns
+// the builder (allows chaining)
+func (b *Builder) WithByteOrder(order binary.ByteOrder) *Builder {
+	b.order = order
+	return b
+}
+
+// Guard the filter with a lock, so that keys can be added and tested,
+// and its bits read, merged and encoded, from many goroutines at once.
+// ResetWith, ReadFrom, UnmarshalJSON and Freeze change _m_, _k_ or
+// whether the filter is frozen, which keys are hashed with outside the
+// lock, so they must not run concurrently with other methods. Returns
+// the builder (allows chaining)
+func (b *Builder) Concurrent() *Builder {
+	b.concurrent = true
+	return b
+}
+
+// Make the Bloom filter, returning an error if the options are missing
+// or conflict
+func (b *Builder) Build() (*BloomFilter, error) {
+	m, k := b.m, b.k
+	switch {
+	case b.estimates && (m != 0 || k != 0):
+		return nil, errors.New("bloom: WithEstimates conflicts with WithCapacity and WithHashes")
+	case b.estimates:
+		if b.n == 0 {
+			return nil, errors.New("bloom: number of items must be positive")
+		}
+		if !(b.fp > 0 && b.fp < 1) {
+			return nil, fmt.Errorf("bloom: false positive rate %v is not between 0 and 1", b.fp)
+		}
+		m, k = EstimateParameters(b.n, b.fp)
+	case m == 0 || k == 0:
+		return nil, errors.New("bloom: needs WithEstimates, or WithCapacity and WithHashes")
+	}
+	h := b.hasher
+	if h == nil {
+		h = fnv.New64
+	}
+	f := NewWithHasher(m, k, h)
+	f.seed = seedBytes(b.seed)
+	f.order = b.order
+	if b.concurrent {
+		f.mu = new(sync.RWMutex)
+	}
+	return f, nil
+}
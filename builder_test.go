@@ -0,0 +1,180 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	f, err := NewBuilder().WithCapacity(1000).WithHashes(4).Build()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Cap() != 1000 || f.K() != 4 {
+		t.Errorf("Expected m=1000, k=4, got m=%v, k=%v", f.Cap(), f.K())
+	}
+	f, err = NewBuilder().WithEstimates(1000, 0.01).Build()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if m, k := EstimateParameters(1000, 0.01); f.Cap() != m || f.K() != k {
+		t.Errorf("Expected m=%v, k=%v, got m=%v, k=%v", m, k, f.Cap(), f.K())
+	}
+}
+
+func TestBuilderMatchesConstructors(t *testing.T) {
+	n1 := []byte("Bess")
+	f, _ := NewBuilder().WithCapacity(1000).WithHashes(4).WithSeed(7).Build()
+	if fmt.Sprint(f.Locations(n1)) != fmt.Sprint(NewWithSeed(1000, 4, 7).Locations(n1)) {
+		t.Errorf("WithSeed should locate keys as NewWithSeed")
+	}
+	f, _ = NewBuilder().WithCapacity(1000).WithHashes(4).WithHasher(newTestHasher).Build()
+	if fmt.Sprint(f.Locations(n1)) != fmt.Sprint(NewWithHasher(1000, 4, newTestHasher).Locations(n1)) {
+		t.Errorf("WithHasher should locate keys as NewWithHasher")
+	}
+	f, _ = NewBuilder().WithCapacity(1000).WithHashes(4).WithByteOrder(binary.LittleEndian).Build()
+	f.AddUint64(100)
+	if !New(1000, 4).WithByteOrder(binary.LittleEndian).AddUint64(100).b.Equal(f.b) {
+		t.Errorf("WithByteOrder should encode integer keys as the filter's WithByteOrder")
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	for name, b := range map[string]*Builder{
+		"nothing":                  NewBuilder(),
+		"capacity only":            NewBuilder().WithCapacity(1000),
+		"hashes only":              NewBuilder().WithHashes(4),
+		"capacity and estimates":   NewBuilder().WithCapacity(1000).WithEstimates(100, 0.01),
+		"hashes and estimates":     NewBuilder().WithHashes(4).WithEstimates(100, 0.01),
+		"no items":                 NewBuilder().WithEstimates(0, 0.01),
+		"false positive rate of 1": NewBuilder().WithEstimates(100, 1),
+		"negative false positives": NewBuilder().WithEstimates(100, -0.1),
+	} {
+		if f, err := b.Build(); err == nil {
+			t.Errorf("Building with %v should fail, got %v", name, f)
+		}
+	}
+}
+
+func TestBuilderConcurrent(t *testing.T) {
+	f, err := NewBuilder().WithEstimates(10000, 0.01).Concurrent().Build()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				f.AddInt(g*1000 + i)
+				if !f.TestInt(g*1000 + i) {
+					t.Errorf("%v should be in right after Add.", g*1000+i)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if f.Len() != 8000 {
+		t.Errorf("Expected 8000 keys, got %v", f.Len())
+	}
+}
+
+// Run with -race: every method but those changing _m_ and _k_ may run
+// alongside Add on a concurrent filter
+func TestBuilderConcurrentReaders(t *testing.T) {
+	f, err := NewBuilder().WithEstimates(10000, 0.01).Concurrent().Build()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	g := New(f.Cap(), f.K())
+	readers := []func(){
+		func() { f.Stats() },
+		func() { f.Len() },
+		func() { f.Bytes() },
+		func() { f.MarshalJSON() },
+		func() { f.WriteTo(io.Discard) },
+		func() { EncodeSparse(io.Discard, f) },
+		func() { f.EncodeDelta(io.Discard, f) },
+		func() { f.IsSubsetOf(f) },
+		func() { f.EstimateJaccard(g) },
+		func() { g.Copy().MergeChecked(f, 1) },
+		func() { f.MergeChecked(g, 1) },
+		func() { UnionAll([]*BloomFilter{g, f}) },
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			f.AddInt(i)
+		}
+	}()
+	for _, read := range readers {
+		wg.Add(1)
+		go func(read func()) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				read()
+			}
+		}(read)
+	}
+	wg.Wait()
+	if f.Len() != 1000 {
+		t.Errorf("Expected 1000 keys, got %v", f.Len())
+	}
+}
+
+func TestBuilderConcurrentDedup(t *testing.T) {
+	f, err := NewBuilder().WithEstimates(100000, 0.001).Concurrent().Build()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	items := make([][]byte, 10000)
+	for i := range items {
+		items[i] = []byte(fmt.Sprint(i))
+	}
+	// every goroutine deduplicates the same items: between them they
+	// keep each at most once, and all but the odd false positive
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	kept := 0
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unseen := f.Dedup(items)
+			mu.Lock()
+			kept += len(unseen)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if kept > len(items) || kept < len(items)*99/100 {
+		t.Errorf("Expected each of %v items kept once, got %v", len(items), kept)
+	}
+	// and TestOrAdd lets only one goroutine add a key
+	added := 0
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n := 0
+			for i := 0; i < 10000; i++ {
+				if !f.TestOrAdd([]byte(fmt.Sprint("new ", i))) {
+					n++
+				}
+			}
+			mu.Lock()
+			added += n
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if added > 10000 {
+		t.Errorf("Expected at most 10000 keys added, got %v", added)
+	}
+}
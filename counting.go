@@ -0,0 +1,192 @@
+package bloom
+
+/*
+A counting Bloom filter replaces each bit of a Bloom filter with a small
+counter, so keys can be removed as well as added. Adding a key increments
+the counters at each of its _k_ locations, removing it decrements them,
+and a key tests present when all of its counters are non-zero.
+
+Counters are 4 bits wide, packed two per byte, so a counting filter takes
+four times the memory of a Bloom filter with the same _m_. A counter that
+reaches 15 is saturated: it is never incremented or decremented again,
+since its true count is unknown. Saturation is very unlikely with sensible
+parameters, but removing keys that were never added, or adding the same
+key many times, can saturate counters; a saturated counter keeps its
+location set for good, and decrementing a counter shared with a key that
+was never added produces false negatives.
+*/
+
+import (
+	"hash"
+	"hash/fnv"
+)
+
+// value at which a counter saturates
+const maxCount = 15
+
+type CountingBloomFilter struct {
+	m      uint
+	k      uint
+	counts []byte
+	hasher hash.Hash64
+}
+
+// Create a new counting Bloom filter with _m_ counters and _k_ hashing
+// functions. As with New, zero values of _m_ or _k_ are taken as 1.
+func NewCounting(m uint, k uint) *CountingBloomFilter {
+	if m == 0 {
+		m = 1
+	}
+	if k == 0 {
+		k = 1
+	}
+	return &CountingBloomFilter{m, k, make([]byte, (m+1)/2), fnv.New64()}
+}
+
+// Create a new counting Bloom filter for about n items with fp
+// false positive rate
+func NewCountingWithEstimates(n uint, fp float64) *CountingBloomFilter {
+	m, k := EstimateParameters(n, fp)
+	return NewCounting(m, k)
+}
+
+// Return the capacity, _m_, of a counting Bloom filter
+func (c *CountingBloomFilter) Cap() uint {
+	return c.m
+}
+
+// Return the number of hash functions used
+func (c *CountingBloomFilter) K() uint {
+	return c.k
+}
+
+// get the counter at location i
+func (c *CountingBloomFilter) count(i uint) byte {
+	if i%2 == 0 {
+		return c.counts[i/2] & 0x0f
+	}
+	return c.counts[i/2] >> 4
+}
+
+// set the counter at location i to v
+func (c *CountingBloomFilter) setCount(i uint, v byte) {
+	if i%2 == 0 {
+		c.counts[i/2] = c.counts[i/2]&0xf0 | v
+	} else {
+		c.counts[i/2] = c.counts[i/2]&0x0f | v<<4
+	}
+}
+
+// Add data to the counting Bloom filter. Returns the filter (allows chaining)
+func (c *CountingBloomFilter) Add(data []byte) *CountingBloomFilter {
+	for _, loc := range locations(c.hasher, data, c.m, c.k) {
+		if n := c.count(loc); n < maxCount {
+			c.setCount(loc, n+1)
+		}
+	}
+	return c
+}
+
+// Add data to the counting Bloom filter n times, incrementing its
+// counters by n up to saturation. Returns the filter (allows chaining)
+func (c *CountingBloomFilter) AddN(data []byte, n uint) *CountingBloomFilter {
+	for _, loc := range locations(c.hasher, data, c.m, c.k) {
+		if v := uint(c.count(loc)); n < maxCount-v {
+			c.setCount(loc, byte(v+n))
+		} else {
+			c.setCount(loc, maxCount)
+		}
+	}
+	return c
+}
+
+// Estimate how many times data was added, as the smallest of its
+// counters, as in a Count-Min sketch. Other keys sharing all of its
+// counters can only raise the estimate, so it is never below the true
+// count, except that counters saturate: 15 means 15 or more. Removing
+// keys that were never added can break this.
+func (c *CountingBloomFilter) Estimate(data []byte) uint {
+	least := byte(maxCount)
+	for _, loc := range locations(c.hasher, data, c.m, c.k) {
+		if n := c.count(loc); n < least {
+			least = n
+		}
+	}
+	return uint(least)
+}
+
+// Tests for the presence of data in the counting Bloom filter
+func (c *CountingBloomFilter) Test(data []byte) bool {
+	for _, loc := range locations(c.hasher, data, c.m, c.k) {
+		if c.count(loc) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Remove data from the counting Bloom filter. Returns false, leaving
+// the filter unchanged, if data was not in the filter
+func (c *CountingBloomFilter) Remove(data []byte) bool {
+	locs := locations(c.hasher, data, c.m, c.k)
+	for _, loc := range locs {
+		if c.count(loc) == 0 {
+			return false
+		}
+	}
+	for _, loc := range locs {
+		if n := c.count(loc); n < maxCount {
+			c.setCount(loc, n-1)
+		}
+	}
+	return true
+}
+
+// Return the number of saturated counters, which are stuck at 15: their
+// locations stay set whatever is removed, and decrementing them is
+// skipped, so keys sharing them may be kept or lost wrongly. Many
+// saturated counters mean the filter is too small or keys are added
+// repeatedly.
+func (c *CountingBloomFilter) SaturatedCells() uint {
+	saturated := uint(0)
+	for i := uint(0); i < c.m; i++ {
+		if c.count(i) == maxCount {
+			saturated++
+		}
+	}
+	return saturated
+}
+
+// Clear all the data in a counting Bloom filter, removing all keys
+func (c *CountingBloomFilter) ClearAll() *CountingBloomFilter {
+	for i := range c.counts {
+		c.counts[i] = 0
+	}
+	return c
+}
+
+// Remove the keys of other from the counting Bloom filter, decrementing
+// each counter by the matching counter of other, down to zero. Both
+// filters must have the same _m_ and _k_, or ErrIncompatibleParameters
+// is returned. Saturated counters are left alone, as in Remove.
+//
+// This is approximate: a counter shared by a key of other and a key
+// that is not in other may be decremented too far, and clamping at zero
+// hides keys of other that were never added, so keys that remain can
+// test absent afterwards.
+func (c *CountingBloomFilter) Subtract(other *CountingBloomFilter) error {
+	if c.m != other.m || c.k != other.k {
+		return ErrIncompatibleParameters
+	}
+	for i := uint(0); i < c.m; i++ {
+		n, d := c.count(i), other.count(i)
+		if n == maxCount || d == 0 {
+			continue
+		}
+		if d > n {
+			d = n
+		}
+		c.setCount(i, n-d)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestCountingBasic(t *testing.T) {
+	c := NewCounting(1000, 4)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	c.Add(n1)
+	if !c.Test(n1) {
+		t.Errorf("%v should be in.", n1)
+	}
+	if c.Test(n2) {
+		t.Errorf("%v should not be in.", n2)
+	}
+	if !c.Remove(n1) {
+		t.Errorf("%v should have been removed.", n1)
+	}
+	if c.Test(n1) {
+		t.Errorf("%v should not be in after removal.", n1)
+	}
+	if c.Remove(n2) {
+		t.Errorf("%v was never added and should not be removed.", n2)
+	}
+}
+
+func TestCountingAddRemoveCycles(t *testing.T) {
+	c := NewCountingWithEstimates(1000, 0.001)
+	n1 := make([]byte, 4)
+	for i := uint32(0); i < 1000; i++ {
+		binary.BigEndian.PutUint32(n1, i)
+		c.Add(n1)
+	}
+	// remove the even keys, the odd ones must survive
+	for i := uint32(0); i < 1000; i += 2 {
+		binary.BigEndian.PutUint32(n1, i)
+		if !c.Remove(n1) {
+			t.Errorf("%v should have been removed.", n1)
+		}
+	}
+	for i := uint32(1); i < 1000; i += 2 {
+		binary.BigEndian.PutUint32(n1, i)
+		if !c.Test(n1) {
+			t.Errorf("%v should still be in.", n1)
+		}
+	}
+	for i := uint32(1); i < 1000; i += 2 {
+		binary.BigEndian.PutUint32(n1, i)
+		c.Remove(n1)
+	}
+	for i, v := range c.counts {
+		if v != 0 {
+			t.Errorf("Counters at %v should be zero after removing every key, got %x", 2*i, v)
+		}
+	}
+}
+
+func TestCountingSaturation(t *testing.T) {
+	c := NewCounting(1000, 4)
+	n1 := []byte("Bess")
+	if c.SaturatedCells() != 0 {
+		t.Errorf("An empty filter should have no saturated counters")
+	}
+	for i := 0; i < 14; i++ {
+		c.Add(n1)
+	}
+	if c.SaturatedCells() != 0 {
+		t.Errorf("Counters at 14 should not be saturated, got %v", c.SaturatedCells())
+	}
+	for i := 0; i < 6; i++ {
+		c.Add(n1)
+	}
+	distinct := map[uint]bool{}
+	for _, loc := range locations(c.hasher, n1, c.m, c.k) {
+		distinct[loc] = true
+	}
+	if c.SaturatedCells() != uint(len(distinct)) {
+		t.Errorf("Expected %v saturated counters, got %v", len(distinct), c.SaturatedCells())
+	}
+	for _, loc := range locations(c.hasher, n1, c.m, c.k) {
+		if c.count(loc) != maxCount {
+			t.Errorf("Counter at %v should be saturated, got %v", loc, c.count(loc))
+		}
+	}
+	// saturated counters are never decremented
+	for i := 0; i < 20; i++ {
+		if !c.Remove(n1) {
+			t.Errorf("%v should stay in once saturated.", n1)
+		}
+	}
+	if !c.Test(n1) {
+		t.Errorf("%v should stay in once saturated.", n1)
+	}
+}
+
+func TestCountingAddNEstimate(t *testing.T) {
+	c := NewCountingWithEstimates(1000, 0.01)
+	counts := map[string]uint{"Bess": 1, "Jane": 3, "Emma": 7, "Tom": 12, "Mary": 40}
+	for key, n := range counts {
+		c.AddN([]byte(key), n)
+	}
+	for i := 0; i < 200; i++ {
+		c.Add([]byte(fmt.Sprint(i)))
+	}
+	for key, n := range counts {
+		est := c.Estimate([]byte(key))
+		if n >= maxCount && est != maxCount {
+			t.Errorf("%v added %v times should be saturated, got %v", key, n, est)
+		}
+		if n < maxCount && (est < n || est > n+2) {
+			t.Errorf("%v added %v times: estimate %v should be at least %v and close", key, n, est, n)
+		}
+	}
+	if est := c.Estimate([]byte("Anna")); est > 2 {
+		t.Errorf("A key never added should estimate near 0, got %v", est)
+	}
+	// AddN(1) is Add
+	d := NewCounting(1000, 4).AddN([]byte("Bess"), 1)
+	if fmt.Sprint(d.counts) != fmt.Sprint(NewCounting(1000, 4).Add([]byte("Bess")).counts) {
+		t.Errorf("AddN of 1 should increment as Add")
+	}
+	if d.AddN([]byte("Bess"), 0).Estimate([]byte("Bess")) != 1 {
+		t.Errorf("AddN of 0 should change nothing")
+	}
+	if d.AddN([]byte("Bess"), math.MaxUint).Estimate([]byte("Bess")) != maxCount {
+		t.Errorf("AddN of a huge count should saturate, not wrap")
+	}
+}
+
+func TestCountingNibbles(t *testing.T) {
+	c := NewCounting(3, 1)
+	c.setCount(0, 3)
+	c.setCount(1, 15)
+	c.setCount(2, 7)
+	if c.count(0) != 3 || c.count(1) != 15 || c.count(2) != 7 {
+		t.Errorf("Counters should not overlap: %v %v %v", c.count(0), c.count(1), c.count(2))
+	}
+}
+
+func TestCountingZeroParameters(t *testing.T) {
+	for _, c := range [][2]uint{{0, 4}, {1000, 0}, {0, 0}} {
+		f := NewCounting(c[0], c[1])
+		if f.Cap() == 0 || f.K() == 0 {
+			t.Errorf("NewCounting(%v, %v) should give at least one counter and hash, got m=%v, k=%v", c[0], c[1], f.Cap(), f.K())
+		}
+		n1 := []byte("Bess")
+		f.Add(n1)
+		if !f.Test(n1) {
+			t.Errorf("%v should be in.", n1)
+		}
+	}
+}
+
+func TestCountingSubtract(t *testing.T) {
+	c := NewCountingWithEstimates(1000, 0.001)
+	other := NewCountingWithEstimates(1000, 0.001)
+	n1 := make([]byte, 4)
+	for i := uint32(0); i < 1000; i++ {
+		binary.BigEndian.PutUint32(n1, i)
+		c.Add(n1)
+		if i%2 == 0 {
+			other.Add(n1)
+		}
+	}
+	if err := c.Subtract(other); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// other is a subset, so the odd keys must all survive
+	present := 0
+	for i := uint32(0); i < 1000; i++ {
+		binary.BigEndian.PutUint32(n1, i)
+		if i%2 == 1 && !c.Test(n1) {
+			t.Errorf("%v should still be in.", n1)
+		}
+		if i%2 == 0 && c.Test(n1) {
+			present++
+		}
+	}
+	if present > 10 {
+		t.Errorf("%v subtracted keys still test present", present)
+	}
+	if err := c.Subtract(NewCounting(c.Cap(), c.K()+1)); err != ErrIncompatibleParameters {
+		t.Errorf("Expected ErrIncompatibleParameters, got %v", err)
+	}
+}
+
+func TestCountingSubtractOverlapping(t *testing.T) {
+	c := NewCountingWithEstimates(2000, 0.001)
+	other := NewCountingWithEstimates(2000, 0.001)
+	n1 := make([]byte, 4)
+	// c holds 0..999, other 900..1099
+	for i := uint32(0); i < 1100; i++ {
+		binary.BigEndian.PutUint32(n1, i)
+		if i < 1000 {
+			c.Add(n1)
+		}
+		if i >= 900 {
+			other.Add(n1)
+		}
+	}
+	c.Subtract(other)
+	survivors, shared := 0, 0
+	for i := uint32(0); i < 1000; i++ {
+		binary.BigEndian.PutUint32(n1, i)
+		if i < 900 && c.Test(n1) {
+			survivors++
+		}
+		if i >= 900 && c.Test(n1) {
+			shared++
+		}
+	}
+	// the 100 keys of other not in c over-subtract the counters they
+	// share with keys of c, each of which has a few percent chance of
+	// losing one of its locations
+	if survivors < 600 {
+		t.Errorf("Only %v of 900 keys only in c survived", survivors)
+	}
+	if shared > 10 {
+		t.Errorf("%v keys in both filters still test present", shared)
+	}
+}
@@ -0,0 +1,166 @@
+package bloom
+
+/*
+A cuckoo filter (Fan et al., "Cuckoo Filter: Practically Better Than
+Bloom") stores a short fingerprint of each key in one of two candidate
+buckets of four slots. The second bucket is the first xor a hash of the
+fingerprint, so either can be found from the other and the fingerprint
+alone. When both are full, Insert evicts a fingerprint to its other
+bucket, which may evict another in turn, up to a bounded number of kicks.
+
+Unlike a Bloom filter it can delete keys, and for false positive rates
+below about 3% it needs fewer bits per key. It can however fill up:
+Insert fails once the table is about 95% full. Deleting a key that was
+never inserted may delete another key with the same fingerprint.
+*/
+
+import (
+	"hash"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+const (
+	cuckooBucketSize = 4   // slots per bucket
+	cuckooMaxKicks   = 500 // evictions before Insert gives up
+)
+
+type CuckooFilter struct {
+	buckets [][cuckooBucketSize]uint16 // fingerprints, 0 for an empty slot
+	mask    uint                       // number of buckets - 1
+	fpMask  uint64                     // the bits of a fingerprint
+	count   uint
+	hashers *sync.Pool // of hash.Hash64, Reset before use
+	rand    *rand.Rand // chooses the fingerprints to evict
+}
+
+// Create a new cuckoo filter holding at least capacity keys with a false
+// positive rate of about fpRate. Fingerprints have ceil(log2(8/fpRate))
+// bits, at most 16, and the number of buckets is a power of two.
+func NewCuckoo(capacity uint, fpRate float64) *CuckooFilter {
+	bits := math.Ceil(math.Log2(2 * cuckooBucketSize / fpRate))
+	if !(bits >= 1) {
+		bits = 1
+	}
+	if bits > 16 {
+		bits = 16
+	}
+	// buckets are only filled to about 95% before Insert fails
+	want := uint(math.Ceil(float64(capacity) / cuckooBucketSize / 0.95))
+	n := uint(1)
+	for n < want {
+		n <<= 1
+	}
+	return &CuckooFilter{
+		buckets: make([][cuckooBucketSize]uint16, n),
+		mask:    n - 1,
+		fpMask:  1<<uint(bits) - 1,
+		hashers: hasherPool(fnv.New64),
+		rand:    rand.New(rand.NewSource(1)),
+	}
+}
+
+// Return the number of keys the cuckoo filter has slots for
+func (c *CuckooFilter) Cap() uint {
+	return uint(len(c.buckets)) * cuckooBucketSize
+}
+
+// Return the number of keys inserted and not deleted
+func (c *CuckooFilter) Count() uint {
+	return c.count
+}
+
+// the first bucket and the fingerprint of data; fingerprints are never 0
+func (c *CuckooFilter) index(data []byte) (uint, uint16) {
+	h := c.hashers.Get().(hash.Hash64)
+	h.Reset()
+	h.Write(data)
+	sum := mix64(h.Sum64())
+	c.hashers.Put(h)
+	fp := uint16(sum >> 32 & c.fpMask)
+	if fp == 0 {
+		fp = 1
+	}
+	return uint(sum) & c.mask, fp
+}
+
+// the other bucket of a fingerprint in bucket i
+func (c *CuckooFilter) alt(i uint, fp uint16) uint {
+	return (i ^ uint(mix64(uint64(fp)))) & c.mask
+}
+
+// put fp in an empty slot of bucket i, if there is one
+func (c *CuckooFilter) put(i uint, fp uint16) bool {
+	for j, slot := range c.buckets[i] {
+		if slot == 0 {
+			c.buckets[i][j] = fp
+			return true
+		}
+	}
+	return false
+}
+
+// Insert data into the cuckoo filter, returning false if the table is
+// too full: both its buckets are full, and a place could not be made
+// within 500 evictions. The filter is then unchanged.
+func (c *CuckooFilter) Insert(data []byte) bool {
+	i1, fp := c.index(data)
+	i2 := c.alt(i1, fp)
+	if c.put(i1, fp) || c.put(i2, fp) {
+		c.count++
+		return true
+	}
+	type kick struct{ bucket, slot uint }
+	kicks := make([]kick, 0, cuckooMaxKicks)
+	i := i1
+	if c.rand.Intn(2) == 1 {
+		i = i2
+	}
+	for n := 0; n < cuckooMaxKicks; n++ {
+		j := uint(c.rand.Intn(cuckooBucketSize))
+		fp, c.buckets[i][j] = c.buckets[i][j], fp
+		kicks = append(kicks, kick{i, j})
+		i = c.alt(i, fp)
+		if c.put(i, fp) {
+			c.count++
+			return true
+		}
+	}
+	// put the evicted fingerprints back where they were
+	for n := len(kicks) - 1; n >= 0; n-- {
+		k := kicks[n]
+		fp, c.buckets[k.bucket][k.slot] = c.buckets[k.bucket][k.slot], fp
+	}
+	return false
+}
+
+// Tests for the presence of data in the cuckoo filter
+func (c *CuckooFilter) Lookup(data []byte) bool {
+	i1, fp := c.index(data)
+	for _, i := range []uint{i1, c.alt(i1, fp)} {
+		for _, slot := range c.buckets[i] {
+			if slot == fp {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Delete one copy of data from the cuckoo filter, returning false if it
+// was not found
+func (c *CuckooFilter) Delete(data []byte) bool {
+	i1, fp := c.index(data)
+	for _, i := range []uint{i1, c.alt(i1, fp)} {
+		for j, slot := range c.buckets[i] {
+			if slot == fp {
+				c.buckets[i][j] = 0
+				c.count--
+				return true
+			}
+		}
+	}
+	return false
+}
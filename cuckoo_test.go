@@ -0,0 +1,76 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestCuckooInsertLookupDelete(t *testing.T) {
+	c := NewCuckoo(1000, 0.001)
+	if c.Cap() < 1000 {
+		t.Errorf("Expected room for 1000 keys, got %v", c.Cap())
+	}
+	n1 := make([]byte, 4)
+	for i := uint32(0); i < 1000; i++ {
+		binary.BigEndian.PutUint32(n1, i)
+		if !c.Insert(n1) {
+			t.Fatalf("Insert of %v should have succeeded", i)
+		}
+	}
+	if c.Count() != 1000 {
+		t.Errorf("Expected 1000 keys, got %v", c.Count())
+	}
+	for i := uint32(0); i < 1000; i++ {
+		binary.BigEndian.PutUint32(n1, i)
+		if !c.Lookup(n1) {
+			t.Errorf("%v should be in.", i)
+		}
+	}
+	for i := uint32(0); i < 1000; i += 2 {
+		binary.BigEndian.PutUint32(n1, i)
+		if !c.Delete(n1) {
+			t.Errorf("%v should have been deleted.", i)
+		}
+	}
+	if c.Count() != 500 {
+		t.Errorf("Expected 500 keys, got %v", c.Count())
+	}
+	fp := 0
+	for i := uint32(0); i < 1000; i++ {
+		binary.BigEndian.PutUint32(n1, i)
+		if i%2 == 1 && !c.Lookup(n1) {
+			t.Errorf("%v should still be in.", i)
+		}
+		if i%2 == 0 && c.Lookup(n1) {
+			fp++
+		}
+	}
+	if fp > 5 {
+		t.Errorf("%v of 500 deleted keys still found", fp)
+	}
+	if c.Delete([]byte("Bess")) {
+		t.Errorf("Bess was never inserted and should not be deleted.")
+	}
+}
+
+func TestCuckooFull(t *testing.T) {
+	c := NewCuckoo(100, 0.01)
+	n1 := make([]byte, 4)
+	inserted := uint32(0)
+	for ; c.Insert(n1); inserted++ {
+		binary.BigEndian.PutUint32(n1, inserted+1)
+	}
+	if inserted < 100 || uint(inserted) > c.Cap() {
+		t.Errorf("Expected the table to fill after 100 to %v keys, got %v", c.Cap(), inserted)
+	}
+	if c.Count() != uint(inserted) {
+		t.Errorf("Expected %v keys, got %v", inserted, c.Count())
+	}
+	// a failed Insert leaves the keys already inserted in place
+	for i := uint32(0); i < inserted; i++ {
+		binary.BigEndian.PutUint32(n1, i)
+		if !c.Lookup(n1) {
+			t.Errorf("%v should be in.", i)
+		}
+	}
+}
@@ -0,0 +1,575 @@
+package bloom
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mjarco/bitset"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+)
+
+var ErrChecksumMismatch = errors.New("bloom: checksum mismatch")
+
+// written ahead of the gzip stream by EncodeCompressed
+var compressedMagic = []byte("BLMZ")
+
+// Encode f as Encode does, compressed with gzip. Lightly filled filters
+// compress very well.
+func EncodeCompressed(w io.Writer, f *BloomFilter) error {
+	if _, err := w.Write(compressedMagic); err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(w)
+	Encode(zw, f)
+	return zw.Close()
+}
+
+// Decode a filter written by EncodeCompressed
+func DecodeCompressed(r io.Reader) (*BloomFilter, error) {
+	magic := make([]byte, len(compressedMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(magic, compressedMagic) {
+		return nil, errors.New("bloom: not a compressed Bloom filter")
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return Decode(zr)
+}
+
+// written ahead of the representation flag by EncodeSparse
+var sparseMagic = []byte("BLMS")
+
+// representations of the bits written by EncodeSparse
+const (
+	sparseDense  = 0 // as Encode, a bitset
+	sparseSparse = 1 // a list of the bits set
+)
+
+// Encode f in whichever is the smaller of two representations: the
+// bitset, as Encode writes it, or the positions of the bits set as
+// varint deltas, which is much smaller for lightly filled filters. A
+// flag after the magic number records which was chosen.
+func EncodeSparse(w io.Writer, f *BloomFilter) error {
+	if f.mu != nil {
+		f = f.Copy() // so that both representations hold the same bits
+	}
+	var sparse bytes.Buffer
+	buf := make([]byte, binary.MaxVarintLen64)
+	count, prev := uint(0), uint(0)
+	f.SetBits(func(pos uint) bool {
+		sparse.Write(buf[:binary.PutUvarint(buf, uint64(pos-prev))])
+		count, prev = count+1, pos
+		return true
+	})
+	dense := uint(binary.PutUvarint(buf, uint64(f.b.Len()))) + (f.b.Len()+63)/64*8
+	ew := &errWriter{w: w}
+	ew.Write(sparseMagic)
+	if uint(sparse.Len()+binary.PutUvarint(buf, uint64(count))) >= dense {
+		ew.Write([]byte{sparseDense})
+		encodeBody(ew, f)
+		return ew.err
+	}
+	ew.Write([]byte{sparseSparse})
+	for _, v := range []uint{f.m, f.k, count} {
+		ew.Write(buf[:binary.PutUvarint(buf, uint64(v))])
+	}
+	sparse.WriteTo(ew)
+	return ew.err
+}
+
+// Decode a filter written by EncodeSparse, in either representation
+func DecodeSparse(r io.Reader) (*BloomFilter, error) {
+	return DecodeSparseLimited(r, math.MaxUint)
+}
+
+// Decode a filter written by EncodeSparse, as DecodeSparse, rejecting
+// with ErrTooLarge any filter of more than maxBits bits before
+// allocating it, as DecodeLimited does for Encode
+func DecodeSparseLimited(r io.Reader, maxBits uint) (*BloomFilter, error) {
+	header := make([]byte, len(sparseMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(sparseMagic)], sparseMagic) {
+		return nil, errors.New("bloom: not a sparse Bloom filter")
+	}
+	switch header[len(sparseMagic)] {
+	case sparseDense:
+		return decodeBody(r, maxBits)
+	case sparseSparse:
+	default:
+		return nil, fmt.Errorf("bloom: unknown representation %d", header[len(sparseMagic)])
+	}
+	var v [3]uint64 // m, k and the number of bits set
+	for i := range v {
+		var err error
+		if v[i], err = one(r); err != nil {
+			return nil, err
+		}
+	}
+	m, k, count := v[0], v[1], v[2]
+	if m == 0 || k == 0 {
+		return nil, errors.New("bloom: m and k must be positive")
+	}
+	if m > uint64(maxBits) {
+		return nil, fmt.Errorf("%w: %d bits", ErrTooLarge, m)
+	}
+	if count > m {
+		return nil, fmt.Errorf("bloom: %d bits set of %d", count, m)
+	}
+	f := New(uint(m), uint(k))
+	pos := uint64(0)
+	for i := uint64(0); i < count; i++ {
+		delta, err := one(r)
+		if err != nil {
+			return nil, err
+		}
+		if (i > 0 && delta == 0) || delta >= m-pos {
+			return nil, fmt.Errorf("bloom: bit position out of order or beyond m = %d", m)
+		}
+		pos += delta
+		f.b.Set(uint(pos))
+	}
+	return f, nil
+}
+
+// Return f encoded as Encode writes it. The encoding depends only on m,
+// k and the bits set, and the bits only on the keys added and the
+// hasher, so for filters made by New it is the same on every platform
+// and version; the package's tests compare it against a golden file,
+// and downstream code can do the same to catch format changes.
+func GoldenBytes(f *BloomFilter) []byte {
+	var buf bytes.Buffer
+	Encode(&buf, f)
+	return buf.Bytes()
+}
+
+// written ahead of the parameters by EncodeDelta
+var deltaMagic = []byte("BLMD")
+
+// Write the bits set in f but not in since, an earlier copy of it, so
+// that ApplyDelta to since gives f: a magic number, then _m_, _k_, the
+// number of bits and their positions as varint deltas. Bits are only
+// ever set by adding keys, so the deltas of successive copies can be
+// appended to a log instead of rewriting the whole filter. Both filters
+// must have the same _m_, _k_ and seed, or ErrIncompatibleParameters is
+// returned.
+func (f *BloomFilter) EncodeDelta(w io.Writer, since *BloomFilter) error {
+	if err := f.compatible(since); err != nil {
+		return err
+	}
+	fb, _, _ := f.snapshot()
+	sb, _, _ := since.snapshot()
+	diff := fb.Difference(sb)
+	var positions bytes.Buffer
+	buf := make([]byte, binary.MaxVarintLen64)
+	count, prev := uint(0), uint(0)
+	for i := uint(0); i < f.m; i++ {
+		if diff.Test(i) {
+			positions.Write(buf[:binary.PutUvarint(buf, uint64(i-prev))])
+			count, prev = count+1, i
+		}
+	}
+	ew := &errWriter{w: w}
+	ew.Write(deltaMagic)
+	for _, v := range []uint{f.m, f.k, count} {
+		ew.Write(buf[:binary.PutUvarint(buf, uint64(v))])
+	}
+	positions.WriteTo(ew)
+	return ew.err
+}
+
+// Set the bits written by EncodeDelta in the Bloom filter, which must
+// have the _m_ and _k_ it was written with. The delta is read whole
+// before any bit is set, so on error f is unchanged. Returns ErrFrozen
+// if f is frozen.
+func (f *BloomFilter) ApplyDelta(r io.Reader) error {
+	if f.frozen {
+		return ErrFrozen
+	}
+	header := make([]byte, len(deltaMagic))
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header, deltaMagic) {
+		return errors.New("bloom: not a Bloom filter delta")
+	}
+	var v [3]uint64 // m, k and the number of bits
+	for i := range v {
+		var err error
+		if v[i], err = one(r); err != nil {
+			return err
+		}
+	}
+	m, k, count := v[0], v[1], v[2]
+	if m != uint64(f.m) || k != uint64(f.k) {
+		return ErrIncompatibleParameters
+	}
+	if count > m {
+		return fmt.Errorf("bloom: %d bits set of %d", count, m)
+	}
+	positions := make([]uint, 0, count)
+	pos := uint64(0)
+	for i := uint64(0); i < count; i++ {
+		delta, err := one(r)
+		if err != nil {
+			return err
+		}
+		if (i > 0 && delta == 0) || delta >= m-pos {
+			return fmt.Errorf("bloom: bit position out of order or beyond m = %d", m)
+		}
+		pos += delta
+		positions = append(positions, uint(pos))
+	}
+	if f.mu != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+	}
+	for _, p := range positions {
+		f.b.Set(p)
+	}
+	return nil
+}
+
+// written ahead of the number of filters by EncodeMany
+var manyMagic = []byte("BLMA")
+
+// Encode filters into a single stream: a magic number, the number of
+// filters as a varint, then each filter as Encode writes it. The filters
+// may have different parameters.
+func EncodeMany(w io.Writer, filters []*BloomFilter) error {
+	ew := &errWriter{w: w}
+	ew.Write(manyMagic)
+	buf := make([]byte, binary.MaxVarintLen64)
+	ew.Write(buf[:binary.PutUvarint(buf, uint64(len(filters)))])
+	for _, f := range filters {
+		Encode(ew, f)
+	}
+	return ew.err
+}
+
+// Decode the filters written by EncodeMany, in order
+func DecodeMany(r io.Reader) ([]*BloomFilter, error) {
+	header := make([]byte, len(manyMagic))
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header, manyMagic) {
+		return nil, errors.New("bloom: not a sequence of Bloom filters")
+	}
+	count, err := one(r)
+	if err != nil {
+		return nil, err
+	}
+	// not allocated up front, as the count may be corrupt
+	var filters []*BloomFilter
+	for i := uint64(0); i < count; i++ {
+		f, err := Decode(r)
+		if err != nil {
+			return nil, fmt.Errorf("bloom: filter %d of %d: %w", i, count, err)
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+// Encode f as Encode does, followed by the CRC-32 (IEEE) of the
+// encoding, so that DecodeChecked can detect corruption
+func EncodeChecked(w io.Writer, f *BloomFilter) error {
+	crc := crc32.NewIEEE()
+	mw := &errWriter{w: io.MultiWriter(w, crc)}
+	Encode(mw, f)
+	if mw.err != nil {
+		return mw.err
+	}
+	return binary.Write(w, wireOrder, crc.Sum32())
+}
+
+// Decode a filter written by EncodeChecked, returning
+// ErrChecksumMismatch if it was corrupted
+func DecodeChecked(r io.Reader) (*BloomFilter, error) {
+	crc := crc32.NewIEEE()
+	f, err := Decode(io.TeeReader(r, crc))
+	if err != nil {
+		return nil, err
+	}
+	var sum uint32
+	if err := binary.Read(r, wireOrder, &sum); err != nil {
+		return nil, err
+	}
+	if sum != crc.Sum32() {
+		return nil, ErrChecksumMismatch
+	}
+	return f, nil
+}
+
+// Write f to w in the format of Encode, returning the number of bytes
+// written. This implements io.WriterTo.
+func (f *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	ew := &errWriter{w: w}
+	Encode(ew, f)
+	return ew.n, ew.err
+}
+
+// Write f to w in the format of Encode, encoding a copy so that keys
+// can go on being added meanwhile. The copy is made under the filter's
+// lock, when it has one (see Builder.Concurrent), which is held only
+// while the bits are cloned, not during the writes to w.
+func (f *BloomFilter) SnapshotEncode(w io.Writer) error {
+	c := f.Copy()
+	_, err := c.WriteTo(w)
+	return err
+}
+
+// Read a filter in the format of Encode from r into f, returning the
+// number of bytes read. f keeps its hasher and seed, and a zero
+// BloomFilter takes those of New. Unlike most io.ReaderFrom
+// implementations this reads a single filter, not up to EOF, so several
+// filters can be read from one stream.
+func (f *BloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	if f.frozen {
+		return 0, ErrFrozen
+	}
+	cr := &countReader{r: r}
+	g, err := Decode(cr)
+	if err != nil {
+		return cr.n, err
+	}
+	if f.mu != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+	}
+	if f.hashers == nil {
+		f.hashers = g.hashers
+	}
+	f.m, f.k, f.b, f.mask = g.m, g.k, g.b, g.mask
+	f.added, f.novel = 0, 0
+	return cr.n, nil
+}
+
+// a writer counting the bytes written to it and keeping the first
+// error, for writing with Encode
+type errWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (ew *errWriter) Write(p []byte) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+	n, err := ew.w.Write(p)
+	ew.n += int64(n)
+	ew.err = err
+	return n, err
+}
+
+// a reader counting the bytes read from it
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// pack the bits of b into bytes, bit i of the bitset being bit i%8
+// (least significant first) of byte i/8
+func packBits(b *bitset.BitSet) []byte {
+	packed := make([]byte, (b.Len()+7)/8)
+	for i := uint(0); i < b.Len(); i++ {
+		if b.Test(i) {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	return packed
+}
+
+// unpack m bits from bytes packed by packBits, which must be exactly
+// long enough for them
+func unpackBits(packed []byte, m uint) (*bitset.BitSet, error) {
+	if uint(len(packed)) != (m+7)/8 {
+		return nil, fmt.Errorf("bloom: %v bytes of bits for m = %v", len(packed), m)
+	}
+	b := bitset.New(m)
+	for i, c := range packed {
+		for j := uint(0); c != 0; j++ {
+			if c&1 != 0 {
+				pos := uint(i)*8 + j
+				if pos >= m {
+					return nil, fmt.Errorf("bloom: bit %v set beyond m = %v", pos, m)
+				}
+				b.Set(pos)
+			}
+			c >>= 1
+		}
+	}
+	return b, nil
+}
+
+// JSON representation of a filter; Bits are base64-encoded
+type jsonFilter struct {
+	M    uint   `json:"m"`
+	K    uint   `json:"k"`
+	Bits []byte `json:"bits"`
+}
+
+// Encode f as a JSON object holding m, k and the bits of the bitset
+// packed into bytes, base64-encoded. This implements json.Marshaler.
+func (f *BloomFilter) MarshalJSON() ([]byte, error) {
+	if f.mu != nil {
+		f.mu.RLock()
+		defer f.mu.RUnlock()
+	}
+	return json.Marshal(jsonFilter{f.m, f.k, packBits(f.b)})
+}
+
+// Decode a JSON object written by MarshalJSON into f, checking that m
+// and k are positive and that the bits match m. f keeps its hasher and
+// seed, and a zero BloomFilter takes those of New. This implements
+// json.Unmarshaler.
+func (f *BloomFilter) UnmarshalJSON(data []byte) error {
+	if f.frozen {
+		return ErrFrozen
+	}
+	var j jsonFilter
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	if j.M == 0 || j.K == 0 {
+		return fmt.Errorf("bloom: m = %v and k = %v must be positive", j.M, j.K)
+	}
+	if j.Bits == nil {
+		return errors.New("bloom: missing bits")
+	}
+	b, err := unpackBits(j.Bits, j.M)
+	if err != nil {
+		return err
+	}
+	if f.mu != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+	}
+	if f.hashers == nil {
+		f.hashers = hasherPool(fnv.New64)
+	}
+	f.m, f.k, f.b, f.mask = j.M, j.K, b, maskFor(j.M)
+	f.added, f.novel = 0, 0
+	return nil
+}
+
+// Return a copy of the bits of f packed into (m+7)/8 bytes, bit i being
+// bit i%8 (least significant first) of byte i/8, with no header, for
+// callers that keep m and k themselves. FromBytes reverses it.
+func (f *BloomFilter) Bytes() []byte {
+	if f.mu != nil {
+		f.mu.RLock()
+		defer f.mu.RUnlock()
+	}
+	return packBits(f.b)
+}
+
+// Create a Bloom filter of m bits and k hashing functions holding the
+// bits packed by Bytes. It returns ErrLengthMismatch if b is not
+// (m+7)/8 bytes long, and an error if m or k is 0 or a bit beyond m is
+// set. b is not retained.
+func FromBytes(b []byte, m, k uint) (*BloomFilter, error) {
+	if m == 0 || k == 0 {
+		return nil, fmt.Errorf("bloom: m = %v and k = %v must be positive", m, k)
+	}
+	if uint(len(b)) != (m+7)/8 {
+		return nil, fmt.Errorf("%w: %v bytes for m = %v", ErrLengthMismatch, len(b), m)
+	}
+	bits, err := unpackBits(b, m)
+	if err != nil {
+		return nil, err
+	}
+	f := New(m, k)
+	f.b = bits
+	return f, nil
+}
+
+// Write f to the file at path in the format of Encode. The filter is
+// written to a temporary file in the same directory, which then
+// replaces path, so a crash never leaves a partly written file. A file
+// already at path keeps its mode; a new one has mode 0666 less the
+// umask, as os.WriteFile gives it.
+func (f *BloomFilter) SaveToFile(path string) (err error) {
+	tmp, err := createTemp(path)
+	if err != nil {
+		return fmt.Errorf("bloom: saving %v: %w", path, err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			err = fmt.Errorf("bloom: saving %v: %w", path, err)
+		}
+	}()
+	w := bufio.NewWriter(tmp)
+	if _, err = f.WriteTo(w); err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if info, statErr := os.Stat(path); statErr == nil {
+		if err = tmp.Chmod(info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// create a new file named path with a random suffix, in the same
+// directory, with mode 0666 less the umask; os.CreateTemp would give
+// 0600 whatever the umask
+func createTemp(path string) (*os.File, error) {
+	for i := 0; ; i++ {
+		name := path + ".tmp" + strconv.FormatUint(uint64(rand.Uint32()), 10)
+		file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) && i < 100 {
+			continue
+		}
+		return file, err
+	}
+}
+
+// Read a filter saved by SaveToFile from the file at path
+func LoadFromFile(path string) (*BloomFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("bloom: loading %v: %w", path, err)
+	}
+	defer file.Close()
+	f, err := Decode(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("bloom: loading %v: %w", path, err)
+	}
+	return f, nil
+}
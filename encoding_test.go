@@ -0,0 +1,693 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mjarco/bitset"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCompressedRoundTrip(t *testing.T) {
+	f := NewWithEstimates(20000, 0.01)
+	addValues := [][]byte{[]byte("ala"), []byte("ma"), []byte("kota")}
+	f.AddAll(addValues)
+	var buf bytes.Buffer
+	if err := EncodeCompressed(&buf, f); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	g, err := DecodeCompressed(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g.Cap() != f.Cap() || g.K() != f.K() || !g.b.Equal(f.b) {
+		t.Errorf("Did not restore properly")
+	}
+	for _, v := range addValues {
+		if !g.Test(v) {
+			t.Errorf("%v should be in.", v)
+		}
+	}
+}
+
+func TestCompressedIsSmaller(t *testing.T) {
+	f := NewWithEstimates(20000, 0.01)
+	f.AddString("Bess")
+	var plain, compressed bytes.Buffer
+	Encode(&plain, f)
+	EncodeCompressed(&compressed, f)
+	if compressed.Len() >= plain.Len() {
+		t.Errorf("Compressed size %v should be below %v", compressed.Len(), plain.Len())
+	}
+}
+
+func TestDecodeCompressedRejectsPlain(t *testing.T) {
+	var buf bytes.Buffer
+	Encode(&buf, New(1000, 4))
+	if _, err := DecodeCompressed(&buf); err == nil {
+		t.Errorf("Uncompressed input should be rejected")
+	}
+	if _, err := DecodeCompressed(bytes.NewReader(nil)); err == nil {
+		t.Errorf("Empty input should be rejected")
+	}
+}
+
+func TestDecodeHeader(t *testing.T) {
+	f := New(1000, 4)
+	f.AddString("Bess")
+	var buf bytes.Buffer
+	Encode(&buf, f)
+	encoded := buf.Bytes()
+	if !bytes.Equal(encoded[:4], []byte("BLM1")) || encoded[4] != 1 {
+		t.Errorf("Encoded filter should start with the magic number and version, got %v", encoded[:5])
+	}
+	g, err := Decode(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !g.TestString("Bess") {
+		t.Errorf("Did not restore properly")
+	}
+
+	wrongMagic := append([]byte("BLMX"), encoded[4:]...)
+	if _, err := Decode(bytes.NewReader(wrongMagic)); !errors.Is(err, ErrBadMagic) {
+		t.Errorf("Expected ErrBadMagic, got %v", err)
+	}
+	wrongVersion := append([]byte("BLM1\x09"), encoded[5:]...)
+	if _, err := Decode(bytes.NewReader(wrongVersion)); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Expected ErrUnsupportedVersion, got %v", err)
+	}
+	if _, err := Decode(bytes.NewReader(encoded[:3])); err == nil {
+		t.Errorf("A truncated header should be rejected")
+	}
+}
+
+func TestPeekHeader(t *testing.T) {
+	f := New(1000, 4).AddString("Bess")
+	r := bytes.NewReader(GoldenBytes(f))
+	m, k, err := PeekHeader(r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if m != 1000 || k != 4 {
+		t.Errorf("Expected m=1000, k=4, got m=%v, k=%v", m, k)
+	}
+	// only the header and varints are consumed, leaving the bitset
+	if consumed := r.Size() - int64(r.Len()); consumed != int64(len(magic))+1+2+1 {
+		t.Errorf("Expected the header and two varints to be read, read %v bytes", consumed)
+	}
+	if _, err := Decode(r); err == nil {
+		t.Errorf("Decode should fail after the header was consumed")
+	}
+	if _, _, err := PeekHeader(bytes.NewReader([]byte("not a filter"))); !errors.Is(err, ErrBadMagic) {
+		t.Errorf("Expected ErrBadMagic, got %v", err)
+	}
+	if _, _, err := PeekHeader(bytes.NewReader(GoldenBytes(f)[:6])); err == nil {
+		t.Errorf("A truncated header should be rejected")
+	}
+}
+
+func TestDecodeLegacy(t *testing.T) {
+	// written by Encode before format versions were introduced: New(1000,
+	// 4) holding Bess, Jane and Emma
+	legacy, err := os.ReadFile(filepath.Join("testdata", "legacy.bin"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := Decode(bytes.NewReader(legacy)); !errors.Is(err, ErrBadMagic) {
+		t.Errorf("Decode should reject the headerless format, got %v", err)
+	}
+	f, err := DecodeLegacy(bytes.NewReader(legacy))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Cap() != 1000 || f.K() != 4 {
+		t.Errorf("Expected m=1000, k=4, got m=%v, k=%v", f.Cap(), f.K())
+	}
+	for _, name := range []string{"Bess", "Jane", "Emma"} {
+		if !f.Test([]byte(name)) {
+			t.Errorf("%v should be in.", name)
+		}
+	}
+	for _, name := range []string{"Ann", "Mark"} {
+		if f.Test([]byte(name)) {
+			t.Errorf("%v should not be in.", name)
+		}
+	}
+	long := []byte("a key longer than eight bytes")
+	if !f.Add(long).Test(long) {
+		t.Errorf("%s should be in.", long)
+	}
+}
+
+func TestDecodeLengthMismatch(t *testing.T) {
+	for _, length := range []uint{999, 2000, 0} {
+		var buf bytes.Buffer
+		buf.Write(magic)
+		buf.WriteByte(formatVersion)
+		buf.Write(binary.AppendUvarint(nil, 1000))
+		buf.WriteByte(4)
+		bitset.Encode(&buf, bitset.New(length))
+		if _, err := Decode(&buf); !errors.Is(err, ErrLengthMismatch) {
+			t.Errorf("A bitset of %v bits for m = 1000: expected ErrLengthMismatch, got %v", length, err)
+		}
+	}
+}
+
+func TestDecodeZeroParameters(t *testing.T) {
+	for _, c := range [][2]byte{{0, 4}, {8, 0}, {0, 0}} {
+		encoded := append(append([]byte{}, magic...), formatVersion, c[0], c[1])
+		if _, err := Decode(bytes.NewReader(encoded)); err == nil {
+			t.Errorf("Decode should reject m=%v, k=%v", c[0], c[1])
+		}
+	}
+}
+
+func TestDecodeLimited(t *testing.T) {
+	f := New(1000, 4)
+	f.AddString("Bess")
+	var buf bytes.Buffer
+	Encode(&buf, f)
+	g, err := DecodeLimited(bytes.NewReader(buf.Bytes()), 1000)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !g.TestString("Bess") || g.Cap() != 1000 {
+		t.Errorf("Did not restore properly")
+	}
+	if _, err := DecodeLimited(bytes.NewReader(buf.Bytes()), 999); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+	// a header claiming 2^60 bits, with no data to back it
+	huge := append(append([]byte{}, magic...), formatVersion)
+	huge = binary.AppendUvarint(huge, 1<<60)
+	huge = append(huge, 4)
+	if _, err := DecodeLimited(bytes.NewReader(huge), 1<<20); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+	// a small m with a huge bitset
+	var bad bytes.Buffer
+	bad.Write(magic)
+	bad.WriteByte(formatVersion)
+	bad.Write([]byte{100, 4})
+	bad.Write(binary.AppendUvarint(nil, 1<<60))
+	if _, err := DecodeLimited(&bad, 1<<20); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestSparseRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 10, 5000} {
+		f := New(100000, 4)
+		for i := 0; i < n; i++ {
+			f.AddInt(i)
+		}
+		var buf bytes.Buffer
+		if err := EncodeSparse(&buf, f); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		sparse := buf.Bytes()[len(sparseMagic)] == sparseSparse
+		if sparse != (n < 5000) {
+			t.Errorf("With %v keys the sparse representation should be used: %v", n, n < 5000)
+		}
+		var dense bytes.Buffer
+		Encode(&dense, f)
+		if sparse && buf.Len() >= dense.Len()/10 {
+			t.Errorf("With %v keys the sparse encoding should be much smaller, got %v bytes to %v", n, buf.Len(), dense.Len())
+		}
+		g, err := DecodeSparse(&buf)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if g.Cap() != f.Cap() || g.K() != f.K() || !g.b.Equal(f.b) {
+			t.Errorf("With %v keys the filter did not restore properly", n)
+		}
+	}
+}
+
+func TestDecodeSparseMalformed(t *testing.T) {
+	f := New(1000, 4).AddString("Bess").AddString("Jane")
+	var buf bytes.Buffer
+	EncodeSparse(&buf, f)
+	encoded := buf.Bytes()
+	for i := 0; i < len(encoded); i++ {
+		if _, err := DecodeSparse(bytes.NewReader(encoded[:i])); err == nil {
+			t.Errorf("Decoding %v of %v bytes should fail", i, len(encoded))
+		}
+	}
+	bad := append([]byte{}, sparseMagic...)
+	bad = append(bad, sparseSparse, 0x80, 0x08, 4, 2, 100, 0) // a repeated position
+	if _, err := DecodeSparse(bytes.NewReader(bad)); err == nil {
+		t.Errorf("A repeated position should be rejected")
+	}
+	bad = append(bad[:len(bad)-1], 0x90, 0x08) // 100 + 1040, beyond m
+	if _, err := DecodeSparse(bytes.NewReader(bad)); err == nil {
+		t.Errorf("A position beyond m should be rejected")
+	}
+	if _, err := DecodeSparse(bytes.NewReader(append([]byte("BLMS"), 7))); err == nil {
+		t.Errorf("An unknown representation should be rejected")
+	}
+}
+
+func TestDecodeSparseLimited(t *testing.T) {
+	dense := New(64, 4)
+	for i := 0; i < 20; i++ {
+		dense.AddString(fmt.Sprint(i))
+	}
+	// one written sparse, one dense
+	for _, f := range []*BloomFilter{New(1000, 4), dense} {
+		f.AddString("Bess")
+		var buf bytes.Buffer
+		EncodeSparse(&buf, f)
+		g, err := DecodeSparseLimited(bytes.NewReader(buf.Bytes()), f.Cap())
+		if err != nil || !g.TestString("Bess") {
+			t.Errorf("Did not restore properly: %v", err)
+		}
+		if _, err := DecodeSparseLimited(bytes.NewReader(buf.Bytes()), f.Cap()-1); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("Expected ErrTooLarge, got %v", err)
+		}
+	}
+	// a sparse header claiming 2^60 bits, with no data to back it
+	huge := append(append([]byte{}, sparseMagic...), sparseSparse)
+	huge = binary.AppendUvarint(huge, 1<<60)
+	huge = append(huge, 4, 1)
+	if _, err := DecodeSparseLimited(bytes.NewReader(huge), 1<<20); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestDecodeIntoFrozen(t *testing.T) {
+	f := New(1000, 4)
+	var buf bytes.Buffer
+	f.WriteTo(&buf)
+	f.Freeze()
+	if _, err := f.ReadFrom(&buf); err != ErrFrozen {
+		t.Errorf("Expected ErrFrozen, got %v", err)
+	}
+	data, _ := json.Marshal(New(100, 2))
+	if err := json.Unmarshal(data, f); !errors.Is(err, ErrFrozen) {
+		t.Errorf("Expected ErrFrozen, got %v", err)
+	}
+}
+
+// testdata/golden.bin holds a filter made by New(1000, 4) holding Bess,
+// Jane and Emma, as GoldenBytes gives it. It must never change: if this
+// test fails, filters encoded by earlier versions no longer decode, or
+// keys no longer map to the same bits.
+func TestGoldenBytes(t *testing.T) {
+	golden, err := os.ReadFile("testdata/golden.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := New(1000, 4)
+	for _, s := range []string{"Bess", "Jane", "Emma"} {
+		f.AddString(s)
+	}
+	got := GoldenBytes(f)
+	if !bytes.Equal(got, golden) {
+		i := 0
+		for i < len(got) && i < len(golden) && got[i] == golden[i] {
+			i++
+		}
+		t.Fatalf("WIRE FORMAT CHANGED: encoding differs from testdata/golden.bin at byte %v of %v (got %v bytes)",
+			i, len(golden), len(got))
+	}
+	g, err := Decode(bytes.NewReader(golden))
+	if err != nil {
+		t.Fatalf("Decoding the golden file failed: %v", err)
+	}
+	if g.Cap() != 1000 || g.K() != 4 || !g.b.Equal(f.b) {
+		t.Errorf("The golden file should decode to the filter it was made from")
+	}
+	for _, s := range []string{"Bess", "Jane", "Emma"} {
+		if !g.TestString(s) {
+			t.Errorf("%v should be in.", s)
+		}
+	}
+}
+
+func TestDelta(t *testing.T) {
+	f := NewWithEstimates(1000, 0.01).AddString("Bess").AddString("Jane")
+	base := f.Copy()
+	f.AddString("Emma").AddString("Tom")
+	var buf bytes.Buffer
+	if err := f.EncodeDelta(&buf, base); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	full := GoldenBytes(f)
+	if buf.Len() >= len(full)/4 {
+		t.Errorf("A delta of 2 keys should be much smaller than the filter: %v of %v bytes", buf.Len(), len(full))
+	}
+	restored := base.Copy()
+	if err := restored.ApplyDelta(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !restored.b.Equal(f.b) {
+		t.Errorf("Applying the delta to the base should give the full filter")
+	}
+	for _, s := range []string{"Bess", "Jane", "Emma", "Tom"} {
+		if !restored.TestString(s) {
+			t.Errorf("%v should be in.", s)
+		}
+	}
+	// an empty delta changes nothing
+	buf.Reset()
+	f.EncodeDelta(&buf, f)
+	if err := restored.ApplyDelta(&buf); err != nil || !restored.b.Equal(f.b) {
+		t.Errorf("An empty delta should apply cleanly, got %v", err)
+	}
+	if err := f.EncodeDelta(&buf, New(1000, 4)); err != ErrIncompatibleParameters {
+		t.Errorf("Expected ErrIncompatibleParameters, got %v", err)
+	}
+}
+
+func TestApplyDeltaMalformed(t *testing.T) {
+	f := New(1000, 4).AddString("Bess")
+	var buf bytes.Buffer
+	f.EncodeDelta(&buf, New(1000, 4))
+	delta := buf.Bytes()
+	if err := New(1000, 5).ApplyDelta(bytes.NewReader(delta)); err != ErrIncompatibleParameters {
+		t.Errorf("Expected ErrIncompatibleParameters, got %v", err)
+	}
+	g := New(1000, 4)
+	if err := g.ApplyDelta(bytes.NewReader(delta[:len(delta)-1])); err == nil {
+		t.Errorf("A truncated delta should be rejected")
+	}
+	if g.b.Count() != 0 {
+		t.Errorf("A rejected delta should set no bits")
+	}
+	if err := g.ApplyDelta(bytes.NewReader(GoldenBytes(f))); err == nil {
+		t.Errorf("A filter is not a delta")
+	}
+	g.Freeze()
+	if err := g.ApplyDelta(bytes.NewReader(delta)); err != ErrFrozen {
+		t.Errorf("Expected ErrFrozen, got %v", err)
+	}
+}
+
+func TestEncodeMany(t *testing.T) {
+	filters := []*BloomFilter{
+		New(1000, 4).AddString("Bess"),
+		NewWithEstimates(10000, 0.01).AddString("Jane"),
+		New(64, 1),
+	}
+	var buf bytes.Buffer
+	if err := EncodeMany(&buf, filters); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	decoded, err := DecodeMany(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(decoded) != len(filters) {
+		t.Fatalf("Expected %v filters, got %v", len(filters), len(decoded))
+	}
+	for i, f := range filters {
+		g := decoded[i]
+		if g.Cap() != f.Cap() || g.K() != f.K() || !g.b.Equal(f.b) {
+			t.Errorf("Filter %v did not restore properly", i)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Errorf("DecodeMany should read the whole sequence, %v bytes left", buf.Len())
+	}
+}
+
+func TestEncodeManyEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodeMany(&buf, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	decoded, err := DecodeMany(bytes.NewReader(buf.Bytes()))
+	if err != nil || len(decoded) != 0 {
+		t.Errorf("Expected no filters, got %v, %v", decoded, err)
+	}
+	if _, err := DecodeMany(bytes.NewReader(buf.Bytes()[:len(manyMagic)])); err == nil {
+		t.Errorf("A missing count should be rejected")
+	}
+	if _, err := DecodeMany(bytes.NewReader(GoldenBytes(New(1000, 4)))); err == nil {
+		t.Errorf("A single filter should not decode as a sequence")
+	}
+	buf.Reset()
+	EncodeMany(&buf, []*BloomFilter{New(1000, 4), New(1000, 4)})
+	if _, err := DecodeMany(bytes.NewReader(buf.Bytes()[:buf.Len()/2])); err == nil {
+		t.Errorf("A missing filter should be rejected")
+	}
+}
+
+func TestChecked(t *testing.T) {
+	f := New(1000, 4)
+	f.AddString("Bess")
+	var buf bytes.Buffer
+	if err := EncodeChecked(&buf, f); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	encoded := buf.Bytes()
+	g, err := DecodeChecked(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !g.b.Equal(f.b) {
+		t.Errorf("Did not restore properly")
+	}
+
+	// flip a byte of the bitset
+	corrupt := append([]byte{}, encoded...)
+	corrupt[len(corrupt)-10] ^= 0x01
+	if _, err := DecodeChecked(bytes.NewReader(corrupt)); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+	// or of the checksum itself
+	corrupt = append([]byte{}, encoded...)
+	corrupt[len(corrupt)-1] ^= 0x80
+	if _, err := DecodeChecked(bytes.NewReader(corrupt)); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+	if _, err := DecodeChecked(bytes.NewReader(encoded[:len(encoded)-2])); err == nil {
+		t.Errorf("A truncated checksum should be rejected")
+	}
+}
+
+func TestWriteToReadFrom(t *testing.T) {
+	f := NewWithEstimates(1000, 0.01)
+	f.AddString("Bess")
+	var buf bytes.Buffer
+	Encode(&buf, f)
+
+	pr, pw := io.Pipe()
+	written := make(chan int64)
+	go func() {
+		n, err := f.WriteTo(pw)
+		pw.CloseWithError(err)
+		written <- n
+	}()
+	var g BloomFilter
+	read, err := g.ReadFrom(pr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n := <-written; n != int64(buf.Len()) || read != n {
+		t.Errorf("Expected %v bytes written and read, got %v and %v", buf.Len(), n, read)
+	}
+	if g.Cap() != f.Cap() || g.K() != f.K() || !g.TestString("Bess") {
+		t.Errorf("Did not restore properly")
+	}
+	if g.TestString("Jane") {
+		t.Errorf("Jane should not be in.")
+	}
+	h, _ := NewBuilder().WithCapacity(1).WithHashes(1).Concurrent().Build()
+	if _, err := h.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil || !h.TestString("Bess") {
+		t.Errorf("Did not restore a concurrent filter properly: %v", err)
+	}
+}
+
+func TestSnapshotEncode(t *testing.T) {
+	f, _ := NewBuilder().WithEstimates(10000, 0.01).Concurrent().Build()
+	f.AddString("Bess")
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			n1 := make([]byte, 4)
+			for i := 0; i < 1000; i++ {
+				binary.BigEndian.PutUint32(n1, uint32(g*1000+i))
+				f.Add(n1)
+			}
+		}(g)
+	}
+	for i := 0; i < 10; i++ {
+		var buf bytes.Buffer
+		if err := f.SnapshotEncode(&buf); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		g, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("Decoding a snapshot failed: %v", err)
+		}
+		if !g.TestString("Bess") {
+			t.Errorf("A snapshot should hold the keys added before it")
+		}
+	}
+	wg.Wait()
+	var buf bytes.Buffer
+	f.SnapshotEncode(&buf)
+	g, _ := Decode(&buf)
+	if !g.b.Equal(f.b) {
+		t.Errorf("A snapshot of an idle filter should match it")
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	f := New(1001, 4)
+	f.AddAll([][]byte{[]byte("Bess"), []byte("Jane")})
+	data, err := json.Marshal(f)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var g BloomFilter
+	if err := json.Unmarshal(data, &g); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g.Cap() != f.Cap() || g.K() != f.K() || !g.b.Equal(f.b) {
+		t.Errorf("Did not restore properly")
+	}
+	if !g.TestString("Bess") || !g.TestString("Jane") || g.TestString("Tom") {
+		t.Errorf("Restored filter has the wrong keys")
+	}
+}
+
+func TestJSONMalformed(t *testing.T) {
+	malformed := []string{
+		`{"k":4,"bits":"AA=="}`,
+		`{"m":8,"bits":"AA=="}`,
+		`{"m":8,"k":4}`,
+		`{"m":8,"k":4,"bits":"AAA="}`,
+		`{"m":4,"k":4,"bits":"8A=="}`,
+		`{"m":8,"k":4,"bits":"not base64"}`,
+		`[1, 2]`,
+	}
+	for _, data := range malformed {
+		var f BloomFilter
+		if err := json.Unmarshal([]byte(data), &f); err == nil {
+			t.Errorf("%v should be rejected", data)
+		}
+	}
+}
+
+func TestBytes(t *testing.T) {
+	f := New(1001, 4)
+	f.Add([]byte("Bess")).Add([]byte("Jane"))
+	b := f.Bytes()
+	if len(b) != 126 {
+		t.Errorf("Expected 126 bytes for m=1001, got %v", len(b))
+	}
+	g, err := FromBytes(b, f.Cap(), f.K())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g.Cap() != f.Cap() || g.K() != f.K() || !g.b.Equal(f.b) || !g.Test([]byte("Bess")) {
+		t.Errorf("FromBytes should restore the filter")
+	}
+	b[0] ^= 0xff
+	if !g.b.Equal(f.b) {
+		t.Errorf("FromBytes should not retain its bytes")
+	}
+}
+
+func TestFromBytesMalformed(t *testing.T) {
+	b := New(1001, 4).Bytes()
+	if _, err := FromBytes(b, 1000, 4); !errors.Is(err, ErrLengthMismatch) {
+		t.Errorf("Expected ErrLengthMismatch for m=1000, got %v", err)
+	}
+	if _, err := FromBytes(b[:125], 1001, 4); !errors.Is(err, ErrLengthMismatch) {
+		t.Errorf("Expected ErrLengthMismatch for truncated bytes, got %v", err)
+	}
+	b[125] = 0x80
+	if _, err := FromBytes(b, 1001, 4); err == nil {
+		t.Errorf("A bit beyond m should fail")
+	}
+	if _, err := FromBytes(nil, 0, 4); err == nil {
+		t.Errorf("m of 0 should fail")
+	}
+}
+
+func TestSaveLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "filter.bloom")
+	f := NewWithEstimates(1000, 0.01)
+	f.AddString("Bess")
+	if err := f.SaveToFile(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// overwriting an existing file works too
+	f.AddString("Jane")
+	if err := f.SaveToFile(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	g, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !g.b.Equal(f.b) || !g.TestString("Bess") || !g.TestString("Jane") {
+		t.Errorf("Did not restore properly")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Temporary files were left behind: %v", entries)
+	}
+}
+
+func TestSaveFileMode(t *testing.T) {
+	dir := t.TempDir()
+	// a new file has the mode os.WriteFile gives under the umask
+	reference := filepath.Join(dir, "reference")
+	if err := os.WriteFile(reference, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "filter.bloom")
+	if err := New(100, 4).SaveToFile(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want, got := fileMode(t, reference), fileMode(t, path); got != want {
+		t.Errorf("Expected mode %v, got %v", want, got)
+	}
+	// an existing file keeps its mode
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := New(100, 4).SaveToFile(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := fileMode(t, path); got != 0600 {
+		t.Errorf("Expected mode 0600 to be kept, got %v", got)
+	}
+}
+
+func fileMode(t *testing.T, path string) os.FileMode {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Mode().Perm()
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadFromFile(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a not-exist error, got %v", err)
+	}
+	path := filepath.Join(dir, "garbage")
+	os.WriteFile(path, []byte("garbage"), 0644)
+	if _, err := LoadFromFile(path); !errors.Is(err, ErrBadMagic) {
+		t.Errorf("Expected ErrBadMagic, got %v", err)
+	}
+	if err := New(10, 1).SaveToFile(filepath.Join(dir, "missing", "filter")); err == nil {
+		t.Errorf("Saving into a missing directory should fail")
+	}
+}
@@ -0,0 +1,63 @@
+package bloom
+
+/*
+Filter is a Bloom filter for keys of any type T, encoded to bytes by a
+function given when it is made. It saves callers the conversion of every
+key to a []byte, while locating keys exactly as a BloomFilter does with
+the encoded keys. StringKey, IntKey and Uint64Key encode common key types.
+*/
+
+import (
+	"encoding/binary"
+)
+
+type Filter[T any] struct {
+	bf  *BloomFilter
+	enc func(T) []byte
+}
+
+// Create a new Filter for about n keys of type T with fp false positive
+// rate, encoding keys with enc
+func NewFilter[T any](n uint, fp float64, enc func(T) []byte) *Filter[T] {
+	return &Filter[T]{bf: NewWithEstimates(n, fp), enc: enc}
+}
+
+// Add key to the filter. Returns the filter (allows chaining)
+func (f *Filter[T]) Add(key T) *Filter[T] {
+	f.bf.Add(f.enc(key))
+	return f
+}
+
+// Tests for the presence of key in the filter
+func (f *Filter[T]) Test(key T) bool {
+	return f.bf.Test(f.enc(key))
+}
+
+// Tests for the presence of key in the filter, then adds it. Returns
+// whether key was present before
+func (f *Filter[T]) TestAndAdd(key T) bool {
+	return f.bf.TestAndAdd(f.enc(key))
+}
+
+// Return the Bloom filter holding the encoded keys, e.g. to encode it
+func (f *Filter[T]) BloomFilter() *BloomFilter {
+	return f.bf
+}
+
+// Encode a string key as its bytes
+func StringKey(s string) []byte {
+	return []byte(s)
+}
+
+// Encode an int key as 8 big-endian bytes, the same as Uint64Key of its
+// two's complement value, whatever the size of int
+func IntKey(i int) []byte {
+	return Uint64Key(uint64(i))
+}
+
+// Encode a uint64 key as 8 big-endian bytes
+func Uint64Key(u uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, u)
+	return b
+}
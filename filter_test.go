@@ -0,0 +1,51 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestFilterString(t *testing.T) {
+	f := NewFilter(1000, 0.01, StringKey)
+	f.Add("Bess")
+	if !f.Test("Bess") {
+		t.Errorf("Bess should be in.")
+	}
+	if f.Test("Jane") {
+		t.Errorf("Jane should not be in.")
+	}
+	if f.TestAndAdd("Jane") {
+		t.Errorf("Jane should not have been in.")
+	}
+	if !f.TestAndAdd("Jane") {
+		t.Errorf("Jane should have been in.")
+	}
+	if !f.BloomFilter().TestString("Bess") {
+		t.Errorf("Keys should be located as their encoding in a BloomFilter")
+	}
+}
+
+func TestFilterUint64(t *testing.T) {
+	f := NewFilter(1000, 0.01, Uint64Key)
+	for i := uint64(0); i < 1000; i += 2 {
+		f.Add(i)
+	}
+	n1 := make([]byte, 8)
+	for i := uint64(0); i < 1000; i += 2 {
+		binary.BigEndian.PutUint64(n1, i)
+		if !f.Test(i) || !f.BloomFilter().Test(n1) {
+			t.Errorf("%v should be in.", i)
+		}
+	}
+}
+
+func TestFilterInt(t *testing.T) {
+	f := NewFilter(1000, 0.01, IntKey)
+	f.Add(-1)
+	if !f.Test(-1) {
+		t.Errorf("-1 should be in.")
+	}
+	if !f.BloomFilter().Test(Uint64Key(1<<64 - 1)) {
+		t.Errorf("Ints should be encoded as their two's complement")
+	}
+}
@@ -0,0 +1,81 @@
+package bloom
+
+/*
+Filters encoded before format versions were introduced have no header,
+and their keys were located by the hashing of the time: the first two
+32-bit words of the key followed by the FNV-1 digest of the empty input,
+as bytes, not a digest of the key, and the _i_th location a + b*i modulo
+_m_. Their bits are meaningless to a BloomFilter, whose hashing has
+changed since, so DecodeLegacy reads them into a LegacyBloomFilter that
+keeps the old hashing, to test keys against old filters until they are
+rebuilt from their keys.
+*/
+
+import (
+	"encoding/binary"
+	"github.com/mjarco/bitset"
+	"hash/fnv"
+	"io"
+	"math"
+)
+
+type LegacyBloomFilter struct {
+	m, k uint
+	b    *bitset.BitSet
+}
+
+// Read a filter in the headerless format written by Encode before
+// format versions were introduced
+func DecodeLegacy(r io.Reader) (*LegacyBloomFilter, error) {
+	f, err := decodeBody(r, math.MaxUint)
+	if err != nil {
+		return nil, err
+	}
+	return &LegacyBloomFilter{m: f.m, k: f.k, b: f.b}, nil
+}
+
+// Return the capacity, _m_, of a legacy Bloom filter
+func (f *LegacyBloomFilter) Cap() uint {
+	return f.m
+}
+
+// Return the number of hash functions used
+func (f *LegacyBloomFilter) K() uint {
+	return f.k
+}
+
+// get the two base hashes for data as Encode used to: the first eight
+// bytes of data followed by the digest of the empty input
+func legacyHashes(data []byte) (a uint64, b uint64) {
+	if len(data) > 8 {
+		data = data[:8]
+	}
+	sum := fnv.New64().Sum(append(make([]byte, 0, 16), data...))
+	return uint64(binary.BigEndian.Uint32(sum[4:8])), uint64(binary.BigEndian.Uint32(sum[0:4]))
+}
+
+// call fn with each of the _k_ locations of data in the underlying bitset
+func (f *LegacyBloomFilter) forEachLocation(data []byte, fn func(loc uint)) {
+	a, b := legacyHashes(data)
+	m := uint64(f.m)
+	for i := uint64(0); i < uint64(f.k); i++ {
+		fn(uint((a + b*i) % m))
+	}
+}
+
+// Add data to the legacy Bloom filter. Returns the filter (allows chaining)
+func (f *LegacyBloomFilter) Add(data []byte) *LegacyBloomFilter {
+	f.forEachLocation(data, func(loc uint) {
+		f.b.Set(loc)
+	})
+	return f
+}
+
+// Tests for the presence of data in the legacy Bloom filter
+func (f *LegacyBloomFilter) Test(data []byte) bool {
+	present := true
+	f.forEachLocation(data, func(loc uint) {
+		present = present && f.b.Test(loc)
+	})
+	return present
+}
@@ -0,0 +1,94 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestMurmur3Vectors(t *testing.T) {
+	for _, c := range []struct {
+		in     string
+		h1, h2 uint64
+	}{
+		{"", 0, 0},
+		{"hello", 0xcbd8a7b341bd9b02, 0x5b1e906a48ae1d19},
+		{"The quick brown fox jumps over the lazy dog", 0xe34bbc7bbc071b6c, 0x7a433ca9c49a9347},
+	} {
+		h := newMurmur3().(*murmur3)
+		h.Write([]byte(c.in))
+		if h1, h2 := h.Sum128(); h1 != c.h1 || h2 != c.h2 {
+			t.Errorf("murmur3(%q) = %x %x, expected %x %x", c.in, h1, h2, c.h1, c.h2)
+		}
+	}
+}
+
+func TestMurmur3Streaming(t *testing.T) {
+	data := []byte(strings.Repeat("Bess and Jane ", 20))
+	whole := newMurmur3()
+	whole.Write(data)
+	for _, size := range []int{1, 7, 15, 16, 17} {
+		h := newMurmur3()
+		for p := data; len(p) > 0; {
+			n := size
+			if n > len(p) {
+				n = len(p)
+			}
+			h.Write(p[:n])
+			p = p[n:]
+		}
+		if h.Sum64() != whole.Sum64() {
+			t.Errorf("Writing in pieces of %v should not change the hash", size)
+		}
+	}
+}
+
+func TestMurmur3Filter(t *testing.T) {
+	f := NewMurmur3(1000, 4)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	f.Add(n1)
+	if !f.Test(n1) || !f.TestString("Bess") {
+		t.Errorf("%v should be in.", n1)
+	}
+	if f.Test(n2) {
+		t.Errorf("%v should not be in.", n2)
+	}
+	a, b := f.key_hashes(n1)
+	h := newMurmur3().(*murmur3)
+	h.Write(n1)
+	if h1, h2 := h.Sum128(); a != h1 || b != h2 {
+		t.Errorf("The base hashes should be the halves of the 128-bit hash")
+	}
+}
+
+// with sequential keys and m/n = 8, FNV's structure shows up as a false
+// positive rate well above the theoretical one, where murmur3 tracks it
+func TestMurmur3FalsePositiveRate(t *testing.T) {
+	n := uint32(1000)
+	rate := func(f *BloomFilter) float64 {
+		n1 := make([]byte, 4)
+		for i := uint32(0); i < n; i++ {
+			binary.BigEndian.PutUint32(n1, i)
+			f.Add(n1)
+		}
+		fp := 0
+		for i := uint32(0); i < 100000; i++ {
+			binary.BigEndian.PutUint32(n1, n+1+i)
+			if f.Test(n1) {
+				fp++
+			}
+		}
+		return float64(fp) / 100000
+	}
+	m, k := uint(8*n), uint(6)
+	expected := New(m, k).FalsePositiveRate(uint(n))
+	murmur, fnv := rate(NewMurmur3(m, k)), rate(New(m, k))
+	t.Logf("expected %v, murmur3 %v, FNV %v", expected, murmur, fnv)
+	if murmur > 1.3*expected {
+		t.Errorf("murmur3 false positive rate %v should be near %v", murmur, expected)
+	}
+	if murmur > fnv {
+		t.Errorf("murmur3 false positive rate %v should be lower than FNV's %v", murmur, fnv)
+	}
+}
@@ -0,0 +1,110 @@
+package bloom
+
+/*
+A partitioned Bloom filter splits its _m_ bits into _k_ slices of m/k
+bits, and the _i_th hashing function only sets and tests bits of the _i_th
+slice. Every key therefore sets exactly _k_ bits, one per slice, where a
+standard filter may set fewer when two of its locations collide.
+
+Each slice fills independently, so after _n_ keys the false positive rate
+is (1 - (1 - k/m)^n)^k. This is marginally higher than for a standard
+filter of the same size, but the fill ratio of every slice is the same in
+expectation, which makes estimates from the number of bits set more
+robust.
+*/
+
+import (
+	"github.com/mjarco/bitset"
+	"hash"
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+type PartitionedBloomFilter struct {
+	m       uint
+	k       uint
+	b       *bitset.BitSet
+	slice   uint       // bits per slice, m/k
+	hashers *sync.Pool // of hash.Hash64, Reset before use
+}
+
+// Create a new partitioned Bloom filter with _k_ hashing functions and
+// at least _m_ bits; _m_ is rounded up to a multiple of _k_ so that the
+// slices have the same size. Zero values of _m_ or _k_ are taken as 1.
+func NewPartitioned(m uint, k uint) *PartitionedBloomFilter {
+	if k == 0 {
+		k = 1
+	}
+	slice := (m + k - 1) / k
+	if slice == 0 {
+		slice = 1
+	}
+	return &PartitionedBloomFilter{
+		m:       slice * k,
+		k:       k,
+		b:       bitset.New(slice * k),
+		slice:   slice,
+		hashers: hasherPool(fnv.New64),
+	}
+}
+
+// Return the capacity, _m_, of a partitioned Bloom filter
+func (f *PartitionedBloomFilter) Cap() uint {
+	return f.m
+}
+
+// Return the number of hash functions used
+func (f *PartitionedBloomFilter) K() uint {
+	return f.k
+}
+
+// call fn with each of the _k_ locations of data, the _i_th within the
+// _i_th slice, stopping early if fn returns false. The offsets within the
+// slices follow enhanced double hashing modulo the slice size.
+func (f *PartitionedBloomFilter) forEachLocation(data []byte, fn func(loc uint) bool) {
+	h := f.hashers.Get().(hash.Hash64)
+	h.Reset()
+	h.Write(data)
+	sum := mix64(h.Sum64())
+	f.hashers.Put(h)
+	x, y := uint(sum>>32)%f.slice, uint(sum&math.MaxUint32)%f.slice
+	for i := uint(0); i < f.k; i++ {
+		if !fn(i*f.slice + x) {
+			return
+		}
+		x = (x + y) % f.slice
+		y = (y + i + 1) % f.slice
+	}
+}
+
+// Add data to the partitioned Bloom filter. Returns the filter (allows chaining)
+func (f *PartitionedBloomFilter) Add(data []byte) *PartitionedBloomFilter {
+	f.forEachLocation(data, func(loc uint) bool {
+		f.b.Set(loc)
+		return true
+	})
+	return f
+}
+
+// Tests for the presence of data in the partitioned Bloom filter
+func (f *PartitionedBloomFilter) Test(data []byte) bool {
+	present := true
+	f.forEachLocation(data, func(loc uint) bool {
+		present = f.b.Test(loc)
+		return present
+	})
+	return present
+}
+
+// Clear all the data in a partitioned Bloom filter, removing all keys
+func (f *PartitionedBloomFilter) ClearAll() *PartitionedBloomFilter {
+	f.b.ClearAll()
+	return f
+}
+
+// Compute the theoretical false positive rate (1 - (1 - k/m)^n)^k of
+// the partitioned filter after n entries have been stored
+func (f *PartitionedBloomFilter) FalsePositiveRate(n uint) float64 {
+	return math.Pow(1-math.Pow(1-1/float64(f.slice), float64(n)), float64(f.k))
+}
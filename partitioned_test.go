@@ -0,0 +1,72 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+func TestPartitionedBasic(t *testing.T) {
+	f := NewPartitioned(1000, 3)
+	if f.Cap() != 1002 || f.K() != 3 {
+		t.Errorf("Expected m=1002, k=3, got m=%v, k=%v", f.Cap(), f.K())
+	}
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	f.Add(n1)
+	if !f.Test(n1) {
+		t.Errorf("%v should be in.", n1)
+	}
+	if f.Test(n2) {
+		t.Errorf("%v should not be in.", n2)
+	}
+	f.ClearAll()
+	if f.Test(n1) {
+		t.Errorf("%v should not be in after ClearAll.", n1)
+	}
+}
+
+func TestPartitionedLocations(t *testing.T) {
+	f := NewPartitioned(1000, 5)
+	n1 := make([]byte, 4)
+	for i := uint32(0); i < 1000; i++ {
+		binary.BigEndian.PutUint32(n1, i)
+		j := uint(0)
+		f.forEachLocation(n1, func(loc uint) bool {
+			if loc/f.slice != j {
+				t.Fatalf("Location %v of %v is in slice %v", j, n1, loc/f.slice)
+			}
+			j++
+			return true
+		})
+		if j != 5 {
+			t.Errorf("%v should have 5 locations, got %v", n1, j)
+		}
+	}
+	f.Add(n1)
+	if c := f.b.Count(); c != 5 {
+		t.Errorf("A key should set exactly k bits, got %v", c)
+	}
+}
+
+func TestPartitionedFalsePositiveRate(t *testing.T) {
+	n := uint(10000)
+	f := NewPartitioned(100000, 7)
+	r := rand.New(rand.NewSource(1))
+	key := make([]byte, 8)
+	for i := uint(0); i < n; i++ {
+		r.Read(key)
+		f.Add(key)
+	}
+	fps := 0
+	for i := 0; i < 100000; i++ {
+		r.Read(key)
+		if f.Test(key) {
+			fps++
+		}
+	}
+	rate, expected := float64(fps)/100000, f.FalsePositiveRate(n)
+	if rate < 0.8*expected || rate > 1.2*expected {
+		t.Errorf("False positive rate %v should be near %v", rate, expected)
+	}
+}
@@ -0,0 +1,65 @@
+package bloom
+
+/*
+A Pool recycles Bloom filters of the same parameters, for services that
+make and discard many short-lived filters, e.g. one per request. Filters
+are cleared when they are put back, so Get returns an empty filter
+whether it was allocated or recycled, and the bitsets are reused instead
+of being allocated and collected each time.
+*/
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"reflect"
+	"sync"
+)
+
+type Pool struct {
+	m, k uint
+	pool sync.Pool // of *BloomFilter, cleared by Put
+}
+
+// Create a new Pool of Bloom filters with _m_ bits and _k_ hashing
+// functions, made by New when the pool is empty. As in New, m and k of 0
+// are taken as 1.
+func NewPool(m uint, k uint) *Pool {
+	f := New(m, k)
+	p := &Pool{m: f.m, k: f.k}
+	p.pool.New = func() interface{} { return New(p.m, p.k) }
+	return p
+}
+
+// Return an empty Bloom filter from the pool
+func (p *Pool) Get() *BloomFilter {
+	return p.pool.Get().(*BloomFilter)
+}
+
+// Clear f and return it to the pool; f must not be used afterwards.
+// Only filters that locate keys as New(m, k) does are pooled; those
+// with other parameters, a seed, another hasher or byte order, a lock,
+// and frozen ones, are left to the garbage collector, so that Get never
+// returns them.
+func (p *Pool) Put(f *BloomFilter) {
+	if !p.fits(f) {
+		return
+	}
+	f.ClearAll()
+	p.pool.Put(f)
+}
+
+// the type of the hashers of New
+var fnvType = reflect.TypeOf(fnv.New64())
+
+// check that f is as New(p.m, p.k) would make it, but for its keys
+func (p *Pool) fits(f *BloomFilter) bool {
+	if f.m != p.m || f.k != p.k || f.frozen || f.seed != nil || f.mu != nil || f.hashers == nil {
+		return false
+	}
+	if f.byteOrder() != binary.BigEndian {
+		return false
+	}
+	h := f.hashers.Get()
+	defer f.hashers.Put(h)
+	return reflect.TypeOf(h) == fnvType
+}
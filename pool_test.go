@@ -0,0 +1,70 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	p := NewPool(1000, 4)
+	f := p.Get()
+	if f.Cap() != 1000 || f.K() != 4 || f.b.Count() != 0 {
+		t.Errorf("Expected an empty filter with m=1000, k=4")
+	}
+	f.AddString("Bess")
+	p.Put(f)
+	// the filter may or may not be recycled; either way it is empty
+	for i := 0; i < 3; i++ {
+		g := p.Get()
+		if g.Cap() != 1000 || g.K() != 4 {
+			t.Errorf("Expected m=1000, k=4, got m=%v, k=%v", g.Cap(), g.K())
+		}
+		if g.TestString("Bess") || g.b.Count() != 0 || g.Len() != 0 {
+			t.Errorf("A filter from the pool should be empty")
+		}
+		defer p.Put(g)
+	}
+	// cleared when put back, even if never recycled
+	h := New(1000, 4).AddString("Jane")
+	p.Put(h)
+	if h.b.Count() != 0 {
+		t.Errorf("Put should clear the filter")
+	}
+	// only filters locating keys as New(m, k) are pooled
+	concurrent, err := NewBuilder().WithCapacity(1000).WithHashes(4).Concurrent().Build()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for name, other := range map[string]*BloomFilter{
+		"other parameters": New(500, 4),
+		"a seed":           NewWithSeed(1000, 4, 42),
+		"murmur3":          NewMurmur3(1000, 4),
+		"xxhash":           NewXXHash(1000, 4),
+		"little-endian":    New(1000, 4).WithByteOrder(binary.LittleEndian),
+		"a lock":           concurrent,
+	} {
+		other.AddString("Jane")
+		p.Put(other)
+		if other.b.Count() == 0 {
+			t.Errorf("A filter with %v should not be pooled", name)
+		}
+	}
+	g := New(1000, 4).WithByteOrder(binary.BigEndian).AddString("Jane")
+	p.Put(g)
+	if g.b.Count() != 0 {
+		t.Errorf("A filter with the default byte order should be pooled")
+	}
+}
+
+func TestPoolDegenerate(t *testing.T) {
+	p := NewPool(0, 0)
+	f := p.Get()
+	if f.Cap() != 1 || f.K() != 1 {
+		t.Errorf("Expected m=1, k=1, got m=%v, k=%v", f.Cap(), f.K())
+	}
+	f.AddString("Bess")
+	p.Put(f)
+	if f.b.Count() != 0 {
+		t.Errorf("A filter of the clamped parameters should be pooled")
+	}
+}
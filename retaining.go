@@ -0,0 +1,102 @@
+package bloom
+
+/*
+A Bloom filter cannot be resized: its bits do not say which keys set
+them, and a filter of another size maps keys to other locations. A
+key-retaining filter keeps, beside its Bloom filter, the 64-bit FNV hash
+of every distinct key added, from which the base hashes of any filter of
+up to 2^32 bits follow, so Resize can rebuild the filter for another
+false positive rate without the keys themselves.
+
+The hashes are kept in a map, which takes some 40 bytes per distinct key
+against about 10 bits per key for the filter at a 1% false positive
+rate, so a key-retaining filter uses some thirty times the memory of the
+filter alone. Use it only when resizing is worth that.
+*/
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+)
+
+type KeyRetainingFilter struct {
+	f      *BloomFilter
+	hashes map[uint64]struct{} // FNV-1 hash of each distinct key added
+}
+
+// Create a new key-retaining filter for about n items with fp false
+// positive rate, sized by EstimateParameters. Keys are located from
+// their retained hashes, which only address 2^32 bits, so larger
+// filters are rejected with ErrTooLarge, as by Resize.
+func NewKeyRetaining(n uint, fp float64) (*KeyRetainingFilter, error) {
+	m, k, err := retainingParameters(n, fp)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyRetainingFilter{
+		f:      New(m, k),
+		hashes: make(map[uint64]struct{}),
+	}, nil
+}
+
+// get the parameters EstimateParameters gives for n items and fp false
+// positive rate, or ErrTooLarge if they exceed the 2^32-1 bits that
+// retained hashes address
+func retainingParameters(n uint, fp float64) (m uint, k uint, err error) {
+	m, k = EstimateParameters(n, fp)
+	if m > math.MaxUint32 {
+		return 0, 0, fmt.Errorf("%w: %d bits", ErrTooLarge, m)
+	}
+	return m, k, nil
+}
+
+// get the FNV-1 hash of data; its lower and upper 32 bits are the base
+// hashes of data in filters made by New
+func retainedHash(data []byte) uint64 {
+	h := fnv.New64()
+	h.Write(data)
+	return h.Sum64()
+}
+
+// Add data to the key-retaining filter. Returns the filter (allows chaining)
+func (r *KeyRetainingFilter) Add(data []byte) *KeyRetainingFilter {
+	h := retainedHash(data)
+	r.hashes[h] = struct{}{}
+	r.f.addHashes(uint64(uint32(h)), h>>32)
+	return r
+}
+
+// Tests for the presence of data in the key-retaining filter
+func (r *KeyRetainingFilter) Test(data []byte) bool {
+	return r.f.Test(data)
+}
+
+// Return the number of distinct keys added. Keys with the same 64-bit
+// hash count once, which is very unlikely below billions of keys.
+func (r *KeyRetainingFilter) Len() uint {
+	return uint(len(r.hashes))
+}
+
+// Return the Bloom filter holding the keys. It is replaced by Resize.
+func (r *KeyRetainingFilter) BloomFilter() *BloomFilter {
+	return r.f
+}
+
+// Rebuild the Bloom filter from the retained hashes, sized as
+// EstimateParameters gives for the keys added (at least 1) and newFP.
+// Filters of more than 2^32 bits locate keys from hashes that are not
+// retained, so those are rejected with ErrTooLarge, as by
+// NewKeyRetaining, leaving the filter unchanged.
+func (r *KeyRetainingFilter) Resize(newFP float64) error {
+	m, k, err := retainingParameters(r.Len(), newFP)
+	if err != nil {
+		return err
+	}
+	f := New(m, k)
+	for h := range r.hashes {
+		f.addHashes(uint64(uint32(h)), h>>32)
+	}
+	r.f = f
+	return nil
+}
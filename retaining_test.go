@@ -0,0 +1,81 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// the fraction of 100000 random keys, not among those added, that test
+// present in f
+func retainingFalsePositives(r *rand.Rand, f *KeyRetainingFilter) float64 {
+	fp := 0
+	n1 := make([]byte, 8)
+	for i := 0; i < 100000; i++ {
+		binary.BigEndian.PutUint64(n1, r.Uint64())
+		if f.Test(n1) {
+			fp++
+		}
+	}
+	return float64(fp) / 100000
+}
+
+func TestKeyRetainingResize(t *testing.T) {
+	f, err := NewKeyRetaining(1000, 0.1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	r := rand.New(rand.NewSource(1))
+	keys := make([][]byte, 1000)
+	for i := range keys {
+		keys[i] = make([]byte, 8)
+		binary.BigEndian.PutUint64(keys[i], r.Uint64())
+		f.Add(keys[i]).Add(keys[i])
+	}
+	if f.Len() != 1000 {
+		t.Errorf("Expected 1000 distinct keys, got %v", f.Len())
+	}
+	before := retainingFalsePositives(r, f)
+	if err := f.Resize(0.001); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, key := range keys {
+		if !f.Test(key) {
+			t.Fatalf("%v should still be in after resizing.", key)
+		}
+	}
+	after := retainingFalsePositives(r, f)
+	if after > 0.002 || after >= before/10 {
+		t.Errorf("Resizing to 0.001 should cut the rate from %v, got %v", before, after)
+	}
+	// the same bits as adding the keys to a filter of the new size
+	g := New(f.BloomFilter().Cap(), f.BloomFilter().K()).AddAll(keys)
+	if !g.b.Equal(f.BloomFilter().b) {
+		t.Errorf("Resizing should set the bits Add sets")
+	}
+}
+
+func TestKeyRetainingTooLarge(t *testing.T) {
+	// some 48 billion bits, too many for the retained hashes
+	if _, err := NewKeyRetaining(5000000000, 0.01); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestKeyRetainingEmpty(t *testing.T) {
+	f, err := NewKeyRetaining(1000, 0.01)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := f.Resize(0.001); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Test([]byte("Bess")) {
+		t.Errorf("An empty filter should hold nothing")
+	}
+	f.Add([]byte("Bess"))
+	if !f.Test([]byte("Bess")) {
+		t.Errorf("Bess should be in.")
+	}
+}
@@ -0,0 +1,74 @@
+package bloom
+
+/*
+A rotating Bloom filter remembers keys for a limited window, for
+deduplicating streams without filling up for good. It holds a ring of
+_slots_ Bloom filters: keys are added to the current one and tested
+against all of them, and each call to Rotate advances to the next slot,
+clearing the keys it held. A key is therefore forgotten by exactly the
+_slots_th rotation after it was last added, however shortly before a
+rotation that was; with rotations at a fixed interval, it is kept for
+between slots-1 and slots intervals.
+
+The filter keeps no clock: callers rotate it on a timer, or after a
+number of keys, to choose the window. Every slot must be sized for the
+keys added between two rotations, and the false positive rate compounds
+over the slots, so is up to _slots_ times that of one.
+*/
+
+type RotatingBloomFilter struct {
+	filters []*BloomFilter
+	current int
+}
+
+// Create a new rotating Bloom filter of slots Bloom filters, each with
+// _m_ bits and _k_ hashing functions. There is at least one slot.
+func NewRotating(m uint, k uint, slots int) *RotatingBloomFilter {
+	if slots < 1 {
+		slots = 1
+	}
+	r := &RotatingBloomFilter{filters: make([]*BloomFilter, slots)}
+	for i := range r.filters {
+		r.filters[i] = New(m, k)
+	}
+	return r
+}
+
+// Advance to the next slot, clearing the oldest keys. Returns the filter
+// (allows chaining)
+func (r *RotatingBloomFilter) Rotate() *RotatingBloomFilter {
+	r.current = (r.current + 1) % len(r.filters)
+	r.filters[r.current].ClearAll()
+	return r
+}
+
+// Add data to the current slot of the rotating Bloom filter. Returns the
+// filter (allows chaining)
+func (r *RotatingBloomFilter) Add(data []byte) *RotatingBloomFilter {
+	r.filters[r.current].Add(data)
+	return r
+}
+
+// Tests for the presence of data in any slot of the rotating Bloom filter
+func (r *RotatingBloomFilter) Test(data []byte) bool {
+	// the slots share their parameters, so data is hashed once
+	a, b := r.filters[0].key_hashes(data)
+	for _, f := range r.filters {
+		if f.testHashes(a, b) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the number of slots
+func (r *RotatingBloomFilter) Slots() int {
+	return len(r.filters)
+}
+
+// Return whether the false positive rate of the current slot exceeds
+// targetFP, as ShouldRotate of a Bloom filter, so that it is time to
+// Rotate. This drives rotation by accuracy rather than by time or count.
+func (r *RotatingBloomFilter) ShouldRotate(targetFP float64) bool {
+	return r.filters[r.current].ShouldRotate(targetFP)
+}
@@ -0,0 +1,88 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestRotatingBasic(t *testing.T) {
+	r := NewRotating(1000, 4, 3)
+	if r.Slots() != 3 {
+		t.Errorf("Expected 3 slots, got %v", r.Slots())
+	}
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	r.Add(n1)
+	if !r.Test(n1) {
+		t.Errorf("%v should be in.", n1)
+	}
+	if r.Test(n2) {
+		t.Errorf("%v should not be in.", n2)
+	}
+	if NewRotating(1000, 4, 0).Slots() != 1 {
+		t.Errorf("There should be at least one slot")
+	}
+}
+
+func TestRotatingExpiry(t *testing.T) {
+	r := NewRotating(1000, 4, 3)
+	old := []byte("Bess")
+	recent := []byte("Jane")
+	r.Add(old)
+	r.Rotate().Rotate()
+	if !r.Test(old) {
+		t.Errorf("%v should still be in after 2 rotations.", old)
+	}
+	r.Add(recent)
+	r.Rotate()
+	if r.Test(old) {
+		t.Errorf("%v should be forgotten after 3 rotations.", old)
+	}
+	if !r.Test(recent) {
+		t.Errorf("%v should still be in after 1 rotation.", recent)
+	}
+	// adding a key again keeps it in the window
+	r.Add(recent)
+	r.Rotate().Rotate()
+	if !r.Test(recent) {
+		t.Errorf("%v should still be in after being added again.", recent)
+	}
+	r.Rotate()
+	if r.Test(recent) {
+		t.Errorf("%v should be forgotten.", recent)
+	}
+}
+
+func TestRotatingForgetsAfterSlots(t *testing.T) {
+	for _, slots := range []int{1, 2, 3, 5} {
+		r := NewRotating(1000, 4, slots)
+		key := []byte("Bess")
+		r.Add(key) // just before a rotation
+		for i := 0; i < slots-1; i++ {
+			r.Rotate()
+		}
+		if !r.Test(key) {
+			t.Errorf("slots=%v: %v should still be in after %v rotations.", slots, key, slots-1)
+		}
+		r.Rotate()
+		if r.Test(key) {
+			t.Errorf("slots=%v: %v should be forgotten after %v rotations.", slots, key, slots)
+		}
+	}
+}
+
+func TestRotatingShouldRotate(t *testing.T) {
+	r := NewRotating(1000, 4, 2)
+	if r.ShouldRotate(0.01) {
+		t.Errorf("An empty slot should not need rotating")
+	}
+	n1 := make([]byte, 4)
+	for i := uint32(0); !r.ShouldRotate(0.01); i++ {
+		binary.BigEndian.PutUint32(n1, i)
+		r.Add(n1)
+	}
+	r.Rotate()
+	if r.ShouldRotate(0.01) {
+		t.Errorf("A fresh slot should not need rotating")
+	}
+}
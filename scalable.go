@@ -0,0 +1,120 @@
+package bloom
+
+/*
+A scalable Bloom filter (Almeida et al., "Scalable Bloom Filters") grows
+to accommodate any number of items while keeping the false positive rate
+bounded. It starts with a single Bloom filter sized for _n0_ items; once
+that many items have been added, a new filter is appended that is
+growthRatio times larger and whose false positive rate is
+tighteningRatio times lower. Items are added to the newest filter only,
+and tested against all of them.
+
+With a target rate _fp_, the _i_th filter uses fp*(1-r)*r^i where r is
+the tightening ratio, so the compound rate stays below _fp_ however many
+filters are added. A growth ratio of 2 and a tightening ratio of 0.8 to
+0.9 are typical choices.
+*/
+
+import (
+	"math"
+)
+
+// the tightening ratio NewScalable takes for one outside (0, 1)
+const defaultTightening = 0.9
+
+type ScalableBloomFilter struct {
+	filters    []*BloomFilter
+	n0         uint
+	fp         float64
+	growth     float64
+	tightening float64
+	count      uint // items added to the newest filter
+	limit      uint // capacity of the newest filter
+}
+
+// Create a new scalable Bloom filter, initially sized for n0 items, with a
+// compound false positive rate of at most fp. Every new filter holds
+// growthRatio (>= 1) times more items than the previous one, with a false
+// positive rate tighteningRatio (between 0 and 1) times lower. An n0 of
+// 0 is taken as 1, a growthRatio below 1 as 1, and a tighteningRatio
+// outside (0, 1) as 0.9, as none of them would bound the number of
+// filters and the compound rate.
+func NewScalable(n0 uint, fp, growthRatio, tighteningRatio float64) *ScalableBloomFilter {
+	if n0 == 0 {
+		n0 = 1
+	}
+	if !(growthRatio >= 1) {
+		growthRatio = 1
+	}
+	if !(tighteningRatio > 0 && tighteningRatio < 1) {
+		tighteningRatio = defaultTightening
+	}
+	s := &ScalableBloomFilter{n0: n0, fp: fp, growth: growthRatio, tightening: tighteningRatio}
+	s.grow()
+	return s
+}
+
+// append a new, larger filter with a tighter false positive rate
+func (s *ScalableBloomFilter) grow() {
+	i := float64(len(s.filters))
+	n := uint(math.Ceil(float64(s.n0) * math.Pow(s.growth, i)))
+	fp := s.fp * (1 - s.tightening) * math.Pow(s.tightening, i)
+	s.filters = append(s.filters, NewWithEstimates(n, fp))
+	s.count = 0
+	s.limit = n
+}
+
+// Add data to the scalable Bloom filter. Returns the filter (allows chaining)
+func (s *ScalableBloomFilter) Add(data []byte) *ScalableBloomFilter {
+	if s.count >= s.limit {
+		s.grow()
+	}
+	s.filters[len(s.filters)-1].Add(data)
+	s.count++
+	return s
+}
+
+// Tests for the presence of data in the scalable Bloom filter
+func (s *ScalableBloomFilter) Test(data []byte) bool {
+	for _, f := range s.filters {
+		if f.Test(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the number of Bloom filters currently in use
+func (s *ScalableBloomFilter) Filters() int {
+	return len(s.filters)
+}
+
+// Return the fraction of the bits set over all the Bloom filters. Older
+// filters are full, near 1/2 with the optimal _k_, so this approaches
+// that as filters are added. This counts the bits set, so takes time in
+// O(m) of all the filters.
+func (s *ScalableBloomFilter) FillRatio() float64 {
+	var set, m uint
+	for _, f := range s.filters {
+		set += f.b.Count()
+		m += f.m
+	}
+	return float64(set) / float64(m)
+}
+
+// Return an estimate of the number of distinct items added, the sum of
+// the estimates of every Bloom filter, as Stats gives them. Items are
+// added to the newest filter only, so the filters hold disjoint items,
+// but for items added again after the filter holding them was
+// outgrown. It is math.MaxUint if a filter has every bit set. This
+// counts the bits set, so takes time in O(m) of all the filters.
+func (s *ScalableBloomFilter) Count() uint {
+	total := 0.0
+	for _, f := range s.filters {
+		total += estimateCount(f.m, f.k, f.b.Count())
+	}
+	if !(total < math.MaxUint) {
+		return math.MaxUint
+	}
+	return uint(math.Round(total))
+}
@@ -0,0 +1,117 @@
+package bloom
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestScalableBasic(t *testing.T) {
+	s := NewScalable(100, 0.01, 2, 0.9)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	s.Add(n1)
+	if !s.Test(n1) {
+		t.Errorf("%v should be in.", n1)
+	}
+	if s.Test(n2) {
+		t.Errorf("%v should not be in.", n2)
+	}
+}
+
+func TestScalableGrowth(t *testing.T) {
+	n0 := 1000
+	fp := 0.01
+	s := NewScalable(uint(n0), fp, 2, 0.8)
+	r := rand.New(rand.NewSource(1))
+	keys := make([][]byte, 10*n0)
+	for i := range keys {
+		keys[i] = make([]byte, 8)
+		r.Read(keys[i])
+		s.Add(keys[i])
+	}
+	if s.Filters() < 2 {
+		t.Errorf("Filter should have grown, has %v filters", s.Filters())
+	}
+	for _, key := range keys {
+		if !s.Test(key) {
+			t.Errorf("%v should be in.", key)
+		}
+	}
+	key := make([]byte, 8)
+	fps := 0
+	for i := 0; i < 100000; i++ {
+		r.Read(key)
+		if s.Test(key) {
+			fps++
+		}
+	}
+	fp_rate := float64(fps) / float64(100000)
+	if fp_rate > fp {
+		t.Errorf("False positive rate too high: filters: %v, target: %f, result: %f", s.Filters(), fp, fp_rate)
+	}
+}
+
+func TestScalableDegenerate(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	key := make([]byte, 8)
+	s := NewScalable(0, 0.01, 2, 0.9)
+	for i := 0; i < 100; i++ {
+		r.Read(key)
+		s.Add(key)
+	}
+	// sized for 1, 2, 4, ... 64 items
+	if s.Filters() != 7 {
+		t.Errorf("n0 of 0 should be taken as 1, got %v filters for 100 keys", s.Filters())
+	}
+	for _, c := range []struct{ growth, tightening float64 }{
+		{0.5, 0.9},
+		{math.NaN(), 0.9},
+		{2, 0},
+		{2, 1},
+		{2, math.NaN()},
+	} {
+		s := NewScalable(100, 0.01, c.growth, c.tightening)
+		for i := 0; i < 1000; i++ {
+			r.Read(key)
+			s.Add(key)
+		}
+		if s.Filters() > 10 {
+			t.Errorf("growth=%v, tightening=%v: %v filters for 1000 keys", c.growth, c.tightening, s.Filters())
+		}
+		fps := 0
+		for i := 0; i < 10000; i++ {
+			r.Read(key)
+			if s.Test(key) {
+				fps++
+			}
+		}
+		if fps > 100 {
+			t.Errorf("growth=%v, tightening=%v: %v false positives in 10000, above 1%%", c.growth, c.tightening, fps)
+		}
+	}
+}
+
+func TestScalableCount(t *testing.T) {
+	s := NewScalable(1000, 0.01, 2, 0.8)
+	if s.Count() != 0 || s.FillRatio() != 0 {
+		t.Errorf("An empty filter should have no items, got %v with fill ratio %v", s.Count(), s.FillRatio())
+	}
+	r := rand.New(rand.NewSource(1))
+	key := make([]byte, 8)
+	for i := 1; i <= 20000; i++ {
+		r.Read(key)
+		s.Add(key)
+		if i%5000 == 0 {
+			if count := s.Count(); math.Abs(float64(count)-float64(i)) > 0.03*float64(i) {
+				t.Errorf("Expected about %v items over %v filters, got %v", i, s.Filters(), count)
+			}
+		}
+	}
+	if s.Filters() < 4 {
+		t.Errorf("Filter should have grown several times, has %v filters", s.Filters())
+	}
+	if fill := s.FillRatio(); fill < 0.3 || fill > 0.55 {
+		t.Errorf("Expected a fill ratio near 1/2, got %v", fill)
+	}
+}
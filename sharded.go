@@ -0,0 +1,86 @@
+package bloom
+
+/*
+A sharded Bloom filter splits its bits among a number of independent
+Bloom filters, each guarded by its own lock, so that it can be used from
+many goroutines at once. Every key belongs to one shard, chosen from its
+hash, and is added to and tested in that shard only; writers adding keys
+of different shards never contend.
+
+Keys spread evenly over the shards, so the false positive rate is that of
+a single Bloom filter with the same total number of bits. Use a few times
+more shards than concurrent writers to keep contention low.
+*/
+
+import (
+	"sync"
+)
+
+type ShardedBloomFilter struct {
+	shards []bloomShard
+}
+
+// a Bloom filter and the lock guarding it
+type bloomShard struct {
+	sync.RWMutex
+	f *BloomFilter
+}
+
+// Create a new sharded Bloom filter with at least _m_ bits in total,
+// split evenly among shards Bloom filters with _k_ hashing functions.
+// There is at least one shard.
+func NewSharded(m uint, k uint, shards int) *ShardedBloomFilter {
+	if shards < 1 {
+		shards = 1
+	}
+	s := &ShardedBloomFilter{shards: make([]bloomShard, shards)}
+	size := (m + uint(shards) - 1) / uint(shards)
+	for i := range s.shards {
+		s.shards[i].f = New(size, k)
+	}
+	return s
+}
+
+// Return the capacity, _m_, of the sharded Bloom filter: the total of
+// its shards
+func (s *ShardedBloomFilter) Cap() uint {
+	return s.shards[0].f.m * uint(len(s.shards))
+}
+
+// Return the number of hash functions used
+func (s *ShardedBloomFilter) K() uint {
+	return s.shards[0].f.k
+}
+
+// Return the number of shards
+func (s *ShardedBloomFilter) Shards() int {
+	return len(s.shards)
+}
+
+// get the hash values of data and the shard it belongs to. The shards
+// share their parameters, so any of them can hash data. The shard is
+// chosen from the mixed first hash, as its low bits also choose the
+// locations within the shard.
+func (s *ShardedBloomFilter) shard(data []byte) (sh *bloomShard, a uint64, b uint64) {
+	a, b = s.shards[0].f.key_hashes(data)
+	return &s.shards[mix64(a)%uint64(len(s.shards))], a, b
+}
+
+// Add data to the sharded Bloom filter; safe for concurrent use.
+// Returns the filter (allows chaining)
+func (s *ShardedBloomFilter) Add(data []byte) *ShardedBloomFilter {
+	sh, a, b := s.shard(data)
+	sh.Lock()
+	sh.f.addHashes(a, b)
+	sh.Unlock()
+	return s
+}
+
+// Tests for the presence of data in the sharded Bloom filter; safe for
+// concurrent use
+func (s *ShardedBloomFilter) Test(data []byte) bool {
+	sh, a, b := s.shard(data)
+	sh.RLock()
+	defer sh.RUnlock()
+	return sh.f.testHashes(a, b)
+}
@@ -0,0 +1,96 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestShardedBasic(t *testing.T) {
+	s := NewSharded(1000, 4, 4)
+	if s.Cap() != 1000 || s.K() != 4 || s.Shards() != 4 {
+		t.Errorf("Expected m=1000, k=4, 4 shards, got m=%v, k=%v, %v shards", s.Cap(), s.K(), s.Shards())
+	}
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	s.Add(n1)
+	if !s.Test(n1) {
+		t.Errorf("%v should be in.", n1)
+	}
+	if s.Test(n2) {
+		t.Errorf("%v should not be in.", n2)
+	}
+	if NewSharded(1000, 4, 0).Shards() != 1 {
+		t.Errorf("There should be at least one shard")
+	}
+}
+
+func TestShardedFalsePositiveRate(t *testing.T) {
+	n := uint(10000)
+	m, k := EstimateParameters(n, 0.01)
+	s := NewSharded(m, k, 8)
+	r := rand.New(rand.NewSource(1))
+	key := make([]byte, 8)
+	for i := uint(0); i < n; i++ {
+		r.Read(key)
+		s.Add(key)
+	}
+	fps := 0
+	for i := 0; i < 100000; i++ {
+		r.Read(key)
+		if s.Test(key) {
+			fps++
+		}
+	}
+	rate, expected := float64(fps)/100000, New(s.Cap(), k).FalsePositiveRate(n)
+	if rate > 1.3*expected {
+		t.Errorf("False positive rate %v should be near that of one filter, %v", rate, expected)
+	}
+}
+
+func TestShardedConcurrent(t *testing.T) {
+	s := NewSharded(100000, 5, 8)
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			n1 := make([]byte, 4)
+			for i := 0; i < 1000; i++ {
+				binary.BigEndian.PutUint32(n1, uint32(g*1000+i))
+				s.Add(n1)
+				if !s.Test(n1) {
+					t.Errorf("%v should be in right after Add.", n1)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	n1 := make([]byte, 4)
+	for i := 0; i < 16000; i++ {
+		binary.BigEndian.PutUint32(n1, uint32(i))
+		if !s.Test(n1) {
+			t.Errorf("%v should be in.", n1)
+		}
+	}
+}
+
+func BenchmarkShardedAddParallel(b *testing.B) {
+	for _, shards := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			n := uint(1000000)
+			m, k := EstimateParameters(n, 0.001)
+			s := NewSharded(m, k, shards)
+			b.RunParallel(func(pb *testing.PB) {
+				n1 := make([]byte, 8)
+				r := rand.New(rand.NewSource(rand.Int63()))
+				for pb.Next() {
+					binary.BigEndian.PutUint64(n1, r.Uint64())
+					s.Add(n1)
+				}
+			})
+		})
+	}
+}
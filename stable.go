@@ -0,0 +1,144 @@
+package bloom
+
+/*
+A stable Bloom filter (Deng and Rafiei, "Approximately Detecting Duplicates
+for Streaming Data using Stable Bloom Filters") holds a fixed number of
+small counters, or cells, and forgets old keys to make room for new ones,
+so it can run over an unbounded stream in bounded memory. Adding a key
+first decrements _P_ cells chosen at random, then sets each of its _k_
+cells to the maximum value _d_; a key tests present when all of its cells
+are non-zero.
+
+The fraction of zero cells converges to a stable point whatever the length
+of the stream, and with it the false positive rate, which NewStable chooses
+_P_ to meet. The price is false negatives: a key is forgotten once enough
+keys have been added after it, sooner with a smaller _d_ or more cells
+decremented per Add. Recently added keys are very likely still present.
+*/
+
+import (
+	"hash"
+	"hash/fnv"
+	"math"
+	"math/rand"
+)
+
+type StableBloomFilter struct {
+	cells  []uint8
+	k      uint
+	p      uint  // cells decremented per Add
+	max    uint8 // value cells are set to, d
+	hasher hash.Hash64
+	rand   *rand.Rand // chooses the cells to decrement
+}
+
+// Create a new stable Bloom filter with cells cells, each set to d
+// (at least 1) when a key is added, and a false positive rate of fpRate
+// at the stable point. _k_ is ceil(log2(1/fpRate)), and _P_ the number
+// of cells decremented per Add that gives fpRate. Cells are chosen from a
+// generator with a fixed seed, so a filter given the same keys behaves
+// the same.
+//
+// As in EstimateParameters, an fpRate of 1 or more, or NaN, gives _k_
+// and _P_ of 1, and one of 0 or below is taken as 1e-12.
+func NewStable(cells uint, d uint8, fpRate float64) *StableBloomFilter {
+	if cells == 0 {
+		cells = 1
+	}
+	if d == 0 {
+		d = 1
+	}
+	k, p := uint(1), uint(1)
+	if _, fpRate, ok := clampEstimate(1, fpRate); ok {
+		k = uint(math.Ceil(math.Log2(1 / fpRate)))
+		if k < 1 {
+			k = 1
+		}
+		p = stableP(cells, k, d, fpRate)
+	}
+	return &StableBloomFilter{
+		cells:  make([]uint8, cells),
+		k:      k,
+		p:      p,
+		max:    d,
+		hasher: fnv.New64(),
+		rand:   rand.New(rand.NewSource(1)),
+	}
+}
+
+// the number of cells to decrement per Add for fpRate false positive
+// rate at the stable point, (1 - fpRate^(1/k))^(1/d) zero cells
+func stableP(m uint, k uint, d uint8, fpRate float64) uint {
+	stable := math.Pow(1-math.Pow(fpRate, 1/float64(k)), 1/float64(d))
+	p := 1 / ((1/stable - 1) * (1/float64(k) - 1/float64(m)))
+	if !(p >= 1) {
+		return 1
+	}
+	if p > float64(m) {
+		return m
+	}
+	return uint(p)
+}
+
+// Return the number of cells of a stable Bloom filter
+func (s *StableBloomFilter) Cap() uint {
+	return uint(len(s.cells))
+}
+
+// Return the number of hash functions used
+func (s *StableBloomFilter) K() uint {
+	return s.k
+}
+
+// Return the number of cells decremented by each Add
+func (s *StableBloomFilter) P() uint {
+	return s.p
+}
+
+// Estimate the false negative rate of keys added long ago, whose cells
+// have since been decremented like any others: the probability that at
+// least one of _k_ cells is zero, 1 - (1 - z)^k, z being the fraction of
+// zero cells. Keys added recently are much less likely to be missing. It
+// starts at 1, as an empty filter holds nothing, and falls to the stable
+// point as keys are added.
+func (s *StableBloomFilter) EstimatedFalseNegativeRate() float64 {
+	zero := 0
+	for _, c := range s.cells {
+		if c == 0 {
+			zero++
+		}
+	}
+	z := float64(zero) / float64(len(s.cells))
+	return 1 - math.Pow(1-z, float64(s.k))
+}
+
+// Add data to the stable Bloom filter, after decrementing _P_ cells
+// starting from a random one. Returns the filter (allows chaining)
+func (s *StableBloomFilter) Add(data []byte) *StableBloomFilter {
+	m := uint(len(s.cells))
+	start := uint(s.rand.Int63n(int64(m)))
+	for i := uint(0); i < s.p; i++ {
+		if c := &s.cells[(start+i)%m]; *c > 0 {
+			*c--
+		}
+	}
+	for _, loc := range s.locations(data) {
+		s.cells[loc] = s.max
+	}
+	return s
+}
+
+// Tests for the presence of data in the stable Bloom filter
+func (s *StableBloomFilter) Test(data []byte) bool {
+	for _, loc := range s.locations(data) {
+		if s.cells[loc] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// get the _k_ cells of data
+func (s *StableBloomFilter) locations(data []byte) []uint {
+	return locations(s.hasher, data, uint(len(s.cells)), s.k)
+}
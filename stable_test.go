@@ -0,0 +1,107 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestStableBasic(t *testing.T) {
+	s := NewStable(10000, 3, 0.01)
+	if s.Cap() != 10000 || s.K() != 7 || s.P() < 1 {
+		t.Errorf("Expected 10000 cells, k=7 and P >= 1, got %v, %v, %v", s.Cap(), s.K(), s.P())
+	}
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	s.Add(n1)
+	if !s.Test(n1) {
+		t.Errorf("%v should be in.", n1)
+	}
+	if s.Test(n2) {
+		t.Errorf("%v should not be in.", n2)
+	}
+}
+
+func TestStableDegenerate(t *testing.T) {
+	// 0 and below are taken as 1e-12, and rates any filter meets give
+	// k and P of 1
+	for _, c := range []struct {
+		fp float64
+		k  uint
+	}{{0, 40}, {-1, 40}, {1, 1}, {2, 1}, {math.NaN(), 1}} {
+		s := NewStable(10000, 3, c.fp)
+		if s.K() != c.k || s.P() < 1 || (c.k == 1 && s.P() != 1) {
+			t.Errorf("fpRate=%v: expected k=%v, got k=%v, P=%v", c.fp, c.k, s.K(), s.P())
+		}
+		n1 := []byte("Bess")
+		if !s.Add(n1).Test(n1) {
+			t.Errorf("fpRate=%v: %v should be in.", c.fp, n1)
+		}
+	}
+}
+
+func TestStableForgets(t *testing.T) {
+	s := NewStable(10000, 3, 0.01)
+	old := []byte("Bess")
+	s.Add(old)
+	r := rand.New(rand.NewSource(1))
+	n1 := make([]byte, 8)
+	recent := make([][]byte, 0, 10)
+	for i := 0; i < 100000; i++ {
+		binary.BigEndian.PutUint64(n1, r.Uint64())
+		s.Add(n1)
+		if i >= 100000-10 {
+			recent = append(recent, append([]byte{}, n1...))
+		}
+	}
+	if s.Test(old) {
+		t.Errorf("%v should have been forgotten.", old)
+	}
+	for _, key := range recent {
+		if !s.Test(key) {
+			t.Errorf("%v was added recently and should be in.", key)
+		}
+	}
+	// at the stable point the false positive rate is about 0.01
+	fp := 0
+	for i := 0; i < 10000; i++ {
+		binary.BigEndian.PutUint64(n1, r.Uint64())
+		if s.Test(n1) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 10000; rate > 0.02 {
+		t.Errorf("False positive rate %v should be about 0.01", rate)
+	}
+}
+
+func TestStableFalseNegativeRate(t *testing.T) {
+	s := NewStable(10000, 3, 0.01)
+	if rate := s.EstimatedFalseNegativeRate(); rate != 1 {
+		t.Errorf("An empty filter should have a rate of 1, got %v", rate)
+	}
+	for i := range s.cells {
+		s.cells[i] = s.max
+	}
+	if rate := s.EstimatedFalseNegativeRate(); rate != 0 {
+		t.Errorf("A filter with no zero cells should have a rate of 0, got %v", rate)
+	}
+	// half the cells zero
+	for i := 0; i < len(s.cells); i += 2 {
+		s.cells[i] = 0
+	}
+	if rate, want := s.EstimatedFalseNegativeRate(), 1-math.Pow(0.5, float64(s.K())); math.Abs(rate-want) > 1e-9 {
+		t.Errorf("Expected a rate of %v, got %v", want, rate)
+	}
+	// after a long stream, old keys are likely to be missing
+	r := rand.New(rand.NewSource(1))
+	n1 := make([]byte, 8)
+	for i := 0; i < 100000; i++ {
+		binary.BigEndian.PutUint64(n1, r.Uint64())
+		s.Add(n1)
+	}
+	if rate := s.EstimatedFalseNegativeRate(); rate < 0.9 || rate >= 1 {
+		t.Errorf("Expected a rate near 0.99 at the stable point, got %v", rate)
+	}
+}
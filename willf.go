@@ -0,0 +1,156 @@
+package bloom
+
+/*
+The willf/bloom package (now bits-and-blooms/bloom) writes filters with
+its WriteTo method as m and k as big-endian uint64s, followed by its
+bitset: the number of bits as a big-endian uint64, then the bits in 64-bit
+big-endian words, bit i being bit i%64 of word i/64. EncodeWillf and
+DecodeWillf read and write that layout, so filters can be moved between
+the packages without adding their keys again.
+
+willf/bloom locates keys differently: it hashes them with 128-bit
+MurmurHash3, twice (the second time with a byte 1 appended), and takes
+the _i_th location from the four 64-bit halves. Its bits are meaningless
+to a BloomFilter, so DecodeWillf reads them into a WillfBloomFilter,
+which locates keys as willf/bloom does and offers nothing that would
+mix the two hashings.
+*/
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/mjarco/bitset"
+	"io"
+	"math"
+)
+
+type WillfBloomFilter struct {
+	m, k uint
+	b    *bitset.BitSet
+}
+
+// Create a new Bloom filter with _m_ bits and _k_ hashing functions,
+// locating keys as willf/bloom does. As in New, m and k of 0 are taken
+// as 1.
+func NewWillf(m uint, k uint) *WillfBloomFilter {
+	if m == 0 {
+		m = 1
+	}
+	if k == 0 {
+		k = 1
+	}
+	return &WillfBloomFilter{m: m, k: k, b: bitset.New(m)}
+}
+
+// Return the capacity, _m_, of a willf/bloom filter
+func (f *WillfBloomFilter) Cap() uint {
+	return f.m
+}
+
+// Return the number of hash functions used
+func (f *WillfBloomFilter) K() uint {
+	return f.k
+}
+
+// Write f in the layout of willf/bloom's WriteTo
+func EncodeWillf(w io.Writer, f *WillfBloomFilter) error {
+	ew := &errWriter{w: w}
+	buf := make([]byte, 8)
+	for _, v := range []uint{f.m, f.k, f.m} { // m, k and the bitset length
+		binary.BigEndian.PutUint64(buf, uint64(v))
+		ew.Write(buf)
+	}
+	for i := uint(0); i < f.m; i += 64 {
+		word := uint64(0)
+		for j := uint(0); j < 64 && i+j < f.m; j++ {
+			if f.b.Test(i + j) {
+				word |= 1 << j
+			}
+		}
+		binary.BigEndian.PutUint64(buf, word)
+		ew.Write(buf)
+	}
+	return ew.err
+}
+
+// Read a filter written by willf/bloom's WriteTo, or by EncodeWillf
+func DecodeWillf(r io.Reader) (*WillfBloomFilter, error) {
+	return DecodeWillfLimited(r, math.MaxUint)
+}
+
+// Read a filter written by willf/bloom's WriteTo, as DecodeWillf,
+// rejecting with ErrTooLarge any filter of more than maxBits bits before
+// allocating it, as DecodeLimited does for Encode
+func DecodeWillfLimited(r io.Reader, maxBits uint) (*WillfBloomFilter, error) {
+	var header [3]uint64 // m, k and the bitset length
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+	m, k, length := header[0], header[1], header[2]
+	if m == 0 || k == 0 {
+		return nil, errors.New("bloom: m and k must be positive")
+	}
+	if m > uint64(maxBits) {
+		return nil, fmt.Errorf("%w: %d bits", ErrTooLarge, m)
+	}
+	if length != m {
+		return nil, fmt.Errorf("bloom: bitset of %d bits for m = %d", length, m)
+	}
+	f := NewWillf(uint(m), uint(k))
+	buf := make([]byte, 8)
+	for i := uint(0); i < f.m; i += 64 {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		word := binary.BigEndian.Uint64(buf)
+		for j := uint(0); word != 0; j++ {
+			if word&1 != 0 {
+				if i+j >= f.m {
+					return nil, fmt.Errorf("bloom: bit %v set beyond m = %v", i+j, f.m)
+				}
+				f.b.Set(i + j)
+			}
+			word >>= 1
+		}
+	}
+	return f, nil
+}
+
+// call fn with each of the _k_ locations of data as willf/bloom computes
+// them: from the halves h of the MurmurHash3 of data and of data with a
+// byte 1 appended, the _i_th is h[i%2] + i*h[2+((i+i%2)%4)/2] modulo _m_
+func (f *WillfBloomFilter) forEachLocation(data []byte, fn func(loc uint) bool) {
+	var h [4]uint64
+	d := &murmur3{}
+	d.Write(data)
+	h[0], h[1] = d.Sum128()
+	d.Write([]byte{1})
+	h[2], h[3] = d.Sum128()
+	for i := uint64(0); i < uint64(f.k); i++ {
+		loc := (h[i%2] + i*h[2+((i+i%2)%4)/2]) % uint64(f.m)
+		if !fn(uint(loc)) {
+			return
+		}
+	}
+}
+
+// Add data to the willf/bloom filter. Returns the filter (allows
+// chaining)
+func (f *WillfBloomFilter) Add(data []byte) *WillfBloomFilter {
+	f.forEachLocation(data, func(loc uint) bool {
+		f.b.Set(loc)
+		return true
+	})
+	return f
+}
+
+// Tests for the presence of data in the willf/bloom filter
+func (f *WillfBloomFilter) Test(data []byte) bool {
+	present := true
+	f.forEachLocation(data, func(loc uint) bool {
+		present = f.b.Test(loc)
+		return present
+	})
+	return present
+}
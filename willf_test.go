@@ -0,0 +1,122 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// testdata/willf.bin was written by WriteTo of willf/bloom v2.0.3: a
+// filter with m = 1000 and k = 4 holding Bess, Jane and Emma
+func TestDecodeWillfGolden(t *testing.T) {
+	golden, err := os.ReadFile("testdata/willf.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := DecodeWillf(bytes.NewReader(golden))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Cap() != 1000 || f.K() != 4 {
+		t.Errorf("Expected m=1000, k=4, got m=%v, k=%v", f.Cap(), f.K())
+	}
+	for _, s := range []string{"Bess", "Jane", "Emma"} {
+		if !f.Test([]byte(s)) {
+			t.Errorf("%v should be in.", s)
+		}
+	}
+	if f.Test([]byte("Mary")) {
+		t.Errorf("Mary should not be in.")
+	}
+	var buf bytes.Buffer
+	if err := EncodeWillf(&buf, f); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), golden) {
+		t.Errorf("EncodeWillf should reproduce the golden file")
+	}
+}
+
+// testdata/willf_long.bin was written by WriteTo of willf/bloom v2.0.3:
+// a filter with m = 4099 and k = 7 holding 100 keys of several murmur3
+// blocks
+func TestDecodeWillfLongKeys(t *testing.T) {
+	golden, err := os.ReadFile("testdata/willf_long.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := DecodeWillf(bytes.NewReader(golden))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Cap() != 4099 || f.K() != 7 {
+		t.Errorf("Expected m=4099, k=7, got m=%v, k=%v", f.Cap(), f.K())
+	}
+	g := NewWillf(4099, 7)
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key %d, long enough to span several 16-byte murmur3 blocks", i))
+		if !f.Test(key) {
+			t.Errorf("%s should be in.", key)
+		}
+		g.Add(key)
+	}
+	if !g.b.Equal(f.b) {
+		t.Errorf("Add should set the bits willf/bloom set")
+	}
+}
+
+func TestDecodeWillfLimited(t *testing.T) {
+	golden, _ := os.ReadFile("testdata/willf.bin")
+	if f, err := DecodeWillfLimited(bytes.NewReader(golden), 1000); err != nil || !f.Test([]byte("Bess")) {
+		t.Errorf("Did not restore properly: %v", err)
+	}
+	if _, err := DecodeWillfLimited(bytes.NewReader(golden), 999); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+	// a header claiming 2^60 bits, with no data to back it
+	huge := binary.BigEndian.AppendUint64(nil, 1<<60)
+	huge = binary.BigEndian.AppendUint64(huge, 4)
+	huge = binary.BigEndian.AppendUint64(huge, 1<<60)
+	if _, err := DecodeWillfLimited(bytes.NewReader(huge), 1<<20); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestWillfRoundTrip(t *testing.T) {
+	f := NewWillf(100, 3)
+	f.Add([]byte("Bess"))
+	var buf bytes.Buffer
+	EncodeWillf(&buf, f)
+	if buf.Len() != 24+2*8 {
+		t.Errorf("Expected %v bytes, got %v", 24+2*8, buf.Len())
+	}
+	g, err := DecodeWillf(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !g.b.Equal(f.b) || !g.Test([]byte("Bess")) {
+		t.Errorf("Did not restore properly")
+	}
+}
+
+func TestDecodeWillfMalformed(t *testing.T) {
+	golden, _ := os.ReadFile("testdata/willf.bin")
+	for _, n := range []int{0, 8, 23, 24, len(golden) - 1} {
+		if _, err := DecodeWillf(bytes.NewReader(golden[:n])); err == nil {
+			t.Errorf("Decoding %v of %v bytes should fail", n, len(golden))
+		}
+	}
+	bad := append([]byte{}, golden...)
+	bad[23]++ // bitset length differs from m
+	if _, err := DecodeWillf(bytes.NewReader(bad)); err == nil {
+		t.Errorf("A bitset length other than m should be rejected")
+	}
+	bad = append([]byte{}, golden...)
+	bad[len(bad)-8] = 0x80 // bit 1023, beyond m
+	if _, err := DecodeWillf(bytes.NewReader(bad)); err == nil {
+		t.Errorf("A bit beyond m should be rejected")
+	}
+}
@@ -0,0 +1,49 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+func TestXorFilter(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	keys := make([][]byte, 100000)
+	for i := range keys {
+		keys[i] = make([]byte, 8)
+		binary.BigEndian.PutUint64(keys[i], r.Uint64())
+	}
+	x, err := NewXorFilter(append(keys, keys[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if !x.Contains(key) {
+			t.Fatalf("%v should be in.", key)
+		}
+	}
+	if perKey := float64(x.Cap()) / float64(len(keys)); perKey > 10 {
+		t.Errorf("Expected about 9.84 bits per key, got %v", perKey)
+	}
+	fp := 0
+	n1 := make([]byte, 8)
+	for i := 0; i < 100000; i++ {
+		binary.BigEndian.PutUint64(n1, r.Uint64())
+		if x.Contains(n1) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 100000; rate < 0.003 || rate > 0.0048 {
+		t.Errorf("False positive rate %v should be about 1/256", rate)
+	}
+}
+
+func TestXorFilterEmpty(t *testing.T) {
+	x, err := NewXorFilter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Contains([]byte("Bess")) && x.Contains([]byte("Jane")) {
+		t.Errorf("An empty filter should not contain Bess and Jane")
+	}
+}
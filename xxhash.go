@@ -0,0 +1,137 @@
+package bloom
+
+/*
+xxHash64 (Yann Collet, https://github.com/Cyan4973/xxHash) is a fast
+non-cryptographic hash, processing input 32 bytes at a time in four
+independent lanes. It is several times faster than FNV on long keys, and
+mixes its output thoroughly. This is a streaming implementation of the
+64-bit variant with a seed of 0, as a hash.Hash64 for NewWithHasher.
+*/
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// variables rather than constants, as the lanes start from sums that
+// overflow
+var (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+type xxhash64 struct {
+	v     [4]uint64 // lane accumulators
+	buf   [32]byte  // input not yet consumed by the lanes
+	nbuf  int
+	total uint64
+}
+
+// Create a new xxHash64 hash.Hash64
+func newXXHash64() hash.Hash64 {
+	x := &xxhash64{}
+	x.Reset()
+	return x
+}
+
+// Create a new Bloom filter with _m_ bits and _k_ hashing functions that
+// hashes keys with xxHash64 rather than FNV. It is faster, notably for
+// long keys, but locates keys differently, so it cannot be combined
+// with, or decode filters encoded by, filters made by New.
+func NewXXHash(m uint, k uint) *BloomFilter {
+	return NewWithHasher(m, k, newXXHash64)
+}
+
+func (x *xxhash64) Reset() {
+	x.v = [4]uint64{xxPrime1 + xxPrime2, xxPrime2, 0, -xxPrime1}
+	x.nbuf = 0
+	x.total = 0
+}
+
+func (x *xxhash64) Size() int      { return 8 }
+func (x *xxhash64) BlockSize() int { return 32 }
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
+
+// consume whole 32-byte stripes of b into the lanes, returning the rest
+func (x *xxhash64) stripes(b []byte) []byte {
+	v1, v2, v3, v4 := x.v[0], x.v[1], x.v[2], x.v[3]
+	for ; len(b) >= 32; b = b[32:] {
+		v1 = xxRound(v1, binary.LittleEndian.Uint64(b[0:8]))
+		v2 = xxRound(v2, binary.LittleEndian.Uint64(b[8:16]))
+		v3 = xxRound(v3, binary.LittleEndian.Uint64(b[16:24]))
+		v4 = xxRound(v4, binary.LittleEndian.Uint64(b[24:32]))
+	}
+	x.v = [4]uint64{v1, v2, v3, v4}
+	return b
+}
+
+func (x *xxhash64) Write(p []byte) (int, error) {
+	n := len(p)
+	x.total += uint64(n)
+	if x.nbuf > 0 {
+		c := copy(x.buf[x.nbuf:], p)
+		x.nbuf += c
+		p = p[c:]
+		if x.nbuf < 32 {
+			return n, nil
+		}
+		x.stripes(x.buf[:])
+		x.nbuf = 0
+	}
+	x.nbuf = copy(x.buf[:], x.stripes(p))
+	return n, nil
+}
+
+func (x *xxhash64) Sum64() uint64 {
+	var h uint64
+	if x.total >= 32 {
+		h = bits.RotateLeft64(x.v[0], 1) + bits.RotateLeft64(x.v[1], 7) +
+			bits.RotateLeft64(x.v[2], 12) + bits.RotateLeft64(x.v[3], 18)
+		for _, v := range x.v {
+			h = xxMergeRound(h, v)
+		}
+	} else {
+		h = xxPrime5
+	}
+	h += x.total
+	p := x.buf[:x.nbuf]
+	for ; len(p) >= 8; p = p[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(p))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(p) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(p)) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		p = p[4:]
+	}
+	for _, c := range p {
+		h ^= uint64(c) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func (x *xxhash64) Sum(b []byte) []byte {
+	s := x.Sum64()
+	return append(b, byte(s>>56), byte(s>>48), byte(s>>40), byte(s>>32),
+		byte(s>>24), byte(s>>16), byte(s>>8), byte(s))
+}
@@ -0,0 +1,85 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestXXHash64Vectors(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		{"Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+	} {
+		h := newXXHash64()
+		h.Write([]byte(c.in))
+		if got := h.Sum64(); got != c.want {
+			t.Errorf("xxHash64(%q) = %x, expected %x", c.in, got, c.want)
+		}
+	}
+}
+
+func TestXXHash64Streaming(t *testing.T) {
+	data := []byte(strings.Repeat("Bess and Jane ", 20))
+	whole := newXXHash64()
+	whole.Write(data)
+	for _, size := range []int{1, 7, 31, 32, 33} {
+		h := newXXHash64()
+		for p := data; len(p) > 0; {
+			n := size
+			if n > len(p) {
+				n = len(p)
+			}
+			h.Write(p[:n])
+			p = p[n:]
+		}
+		if h.Sum64() != whole.Sum64() {
+			t.Errorf("Writing in pieces of %v should not change the hash", size)
+		}
+	}
+}
+
+func TestXXHashFilter(t *testing.T) {
+	f := NewXXHash(1000, 4)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	f.Add(n1)
+	if !f.Test(n1) {
+		t.Errorf("%v should be in.", n1)
+	}
+	if f.Test(n2) {
+		t.Errorf("%v should not be in.", n2)
+	}
+	n, fp := uint(10000), 0.01
+	m, k := EstimateParameters(n, fp)
+	g := NewXXHash(m, k)
+	if rate := empiricalFalsePositiveRate(g, int(n)); rate > 1.5*fp {
+		t.Errorf("False positive rate too high: %v", rate)
+	}
+}
+
+func benchmarkAddHasher(b *testing.B, f *BloomFilter, size int) {
+	key := make([]byte, size)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		binary.BigEndian.PutUint32(key, uint32(i))
+		f.Add(key)
+	}
+}
+
+// the filters are small enough to stay in cache, so that hashing
+// dominates
+func BenchmarkAddFNV(b *testing.B) {
+	benchmarkAddHasher(b, NewWithEstimates(10000, 0.001), 256)
+}
+
+func BenchmarkAddXXHash(b *testing.B) {
+	m, k := EstimateParameters(10000, 0.001)
+	benchmarkAddHasher(b, NewXXHash(m, k), 256)
+}